@@ -0,0 +1,392 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultAdviceRules is used when ADVICE_RULES_FILE is not set. The file
+// doubles as a template for custom rules.
+//
+//go:embed config/advice_rules.json
+var defaultAdviceRules []byte
+
+// AdviceRules are the tunable thresholds of the advice engine. Wind speeds
+// are in km/h as reported by Caiyun.
+type AdviceRules struct {
+	Umbrella        UmbrellaRule   `json:"umbrella"`
+	Layers          []LayerRule    `json:"layers"` // ascending by max_apparent_temperature
+	SunProtectionUV float64        `json:"sun_protection_uv"`
+	MaskAQI         int            `json:"mask_aqi"`
+	Activities      []ActivityRule `json:"activities"`
+}
+
+// UmbrellaRule decides when to carry an umbrella. Either threshold
+// suffices; unset thresholds are not checked.
+type UmbrellaRule struct {
+	PrecipitationProbability *int     `json:"precipitation_probability"`
+	PrecipitationMM          *float64 `json:"precipitation_mm"` // precipitation intensity, mm/h
+}
+
+// needed reports whether a precipitation probability (%) or intensity
+// (mm/h) calls for an umbrella
+func (rule UmbrellaRule) needed(probability int, mm float64) bool {
+	return rule.PrecipitationProbability != nil && probability >= *rule.PrecipitationProbability ||
+		rule.PrecipitationMM != nil && mm >= *rule.PrecipitationMM
+}
+
+// LayerRule suggests clothing for apparent temperatures up to a limit
+type LayerRule struct {
+	MaxApparentTemperature float64   `json:"max_apparent_temperature"`
+	Advice                 localized `json:"advice"`
+}
+
+// ActivityRule lists the conditions an hour must meet to be good for an
+// activity. Unset limits are not checked.
+type ActivityRule struct {
+	ID                          string    `json:"id"`
+	Name                        localized `json:"name"`
+	MinApparentTemperature      *float64  `json:"min_apparent_temperature"`
+	MaxApparentTemperature      *float64  `json:"max_apparent_temperature"`
+	MaxWindSpeed                *float64  `json:"max_wind_speed"`
+	MaxPrecipitationProbability *int      `json:"max_precipitation_probability"`
+	MaxAQI                      *int      `json:"max_aqi"`
+	MaxUV                       *float64  `json:"max_uv"`
+	DaylightOnly                bool      `json:"daylight_only"`
+}
+
+// loadAdviceRules reads rules from path, or the built-in rules when path is
+// empty
+func loadAdviceRules(path string) (*AdviceRules, error) {
+	data := defaultAdviceRules
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var rules AdviceRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	if len(rules.Layers) == 0 {
+		return nil, fmt.Errorf("at least one clothing layer rule is required")
+	}
+	if p := rules.Umbrella.PrecipitationProbability; p != nil && (*p <= 0 || *p > 100) {
+		return nil, fmt.Errorf("umbrella precipitation_probability must be between 1 and 100")
+	}
+	if mm := rules.Umbrella.PrecipitationMM; mm != nil && *mm <= 0 {
+		return nil, fmt.Errorf("umbrella precipitation_mm must be positive")
+	}
+	sort.SliceStable(rules.Layers, func(i, j int) bool {
+		return rules.Layers[i].MaxApparentTemperature < rules.Layers[j].MaxApparentTemperature
+	})
+	seen := make(map[string]bool)
+	for _, activity := range rules.Activities {
+		if activity.ID == "" {
+			return nil, fmt.Errorf("activity without id")
+		}
+		if seen[activity.ID] {
+			return nil, fmt.Errorf("duplicate activity %q", activity.ID)
+		}
+		seen[activity.ID] = true
+	}
+	return &rules, nil
+}
+
+// adviceHour is one hour of forecast with everything the rules look at
+type adviceHour struct {
+	Time                time.Time
+	ApparentTemperature float64
+	WindSpeed           float64
+	PrecipitationProb   int
+	PrecipitationMM     float64
+	AQI                 int
+	UV                  float64
+	Daylight            bool
+}
+
+// adviceHours extracts up to hours upcoming hours from the raw forecast.
+// Caiyun has no hourly UV index, so it is approximated by hourlyUVIndex;
+// the current hour uses the realtime index when present.
+func adviceHours(resp *CaiyunAPIResponse, hours int) []adviceHour {
+	times := newCaiyunTimes(resp)
+	hourly := resp.Result.Hourly
+	uvIndices := hourlyUVIndex(resp)
+	now := time.Now().Truncate(time.Hour)
+
+	var result []adviceHour
+	for i, point := range hourly.ApparentTemperature {
+		t, ok := times.parse("hourly.apparent_temperature.datetime", point.Datetime)
+		if !ok || t.Before(now) {
+			continue
+		}
+		hour := adviceHour{Time: t, ApparentTemperature: point.Value}
+		if i < len(hourly.Wind) {
+			hour.WindSpeed = hourly.Wind[i].Speed
+		}
+		if i < len(hourly.Precipitation) {
+			hour.PrecipitationProb = hourly.Precipitation[i].Probability
+			hour.PrecipitationMM = hourly.Precipitation[i].Value
+		}
+		if i < len(hourly.AirQuality.AQI) {
+			hour.AQI = int(hourly.AirQuality.AQI[i].Value.CHN)
+		}
+		if i < len(hourly.Dswrf) {
+			hour.UV = roundTo(uvIndices[i], 1)
+			hour.Daylight = hourly.Dswrf[i].Value > 0
+		}
+		if len(result) == 0 {
+			if uv, ok := resp.Result.Realtime.LifeIndex["ultraviolet"]; ok {
+				hour.UV = uv.Index
+			}
+		}
+		result = append(result, hour)
+		if len(result) == hours {
+			break
+		}
+	}
+	return result
+}
+
+// Advice is the set of recommendations for the coming hours
+type Advice struct {
+	Location      string           `json:"location"`
+	Lang          Lang             `json:"lang"`
+	From          time.Time        `json:"from"`
+	To            time.Time        `json:"to"`
+	Umbrella      UmbrellaAdvice   `json:"umbrella"`
+	Clothing      ClothingAdvice   `json:"clothing"`
+	SunProtection bool             `json:"sun_protection"`
+	MaxUV         float64          `json:"max_uv"` // approximate beyond the current hour, see hourlyUVIndex
+	Mask          bool             `json:"mask"`
+	MaxAQI        int              `json:"max_aqi"`
+	Activities    []ActivityAdvice `json:"activities"`
+	Summary       []string         `json:"summary"`
+}
+
+// UmbrellaAdvice says whether and from when an umbrella is needed
+type UmbrellaAdvice struct {
+	Needed         bool      `json:"needed"`
+	From           time.Time `json:"from,omitzero"`
+	MaxProbability int       `json:"max_probability"`
+	TotalMM        float64   `json:"total_mm"`
+}
+
+// ClothingAdvice suggests layers for the coldest apparent temperature
+type ClothingAdvice struct {
+	ApparentMin float64 `json:"apparent_temperature_min"`
+	ApparentMax float64 `json:"apparent_temperature_max"`
+	Layers      string  `json:"layers"`
+}
+
+// ActivityAdvice lists the good windows for an activity and why the other
+// hours are not
+type ActivityAdvice struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Recommended bool           `json:"recommended"`
+	Windows     []AdviceWindow `json:"windows"`
+	Reasons     []string       `json:"reasons"`
+}
+
+// AdviceWindow is a run of consecutive good hours
+type AdviceWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// adviceReasons explain why an hour is unsuitable for an activity
+var adviceReasons = map[string]localized{
+	"cold":     {"体感过冷", "too cold"},
+	"hot":      {"体感过热", "too hot"},
+	"wind":     {"风力过大", "too windy"},
+	"rain":     {"可能降水", "rain likely"},
+	"aqi":      {"空气质量差", "poor air quality"},
+	"uv":       {"紫外线过强", "UV too strong"},
+	"daylight": {"天黑", "dark"},
+}
+
+// adviceReasonOrder keeps reasons in a stable, readable order
+var adviceReasonOrder = []string{"rain", "cold", "hot", "wind", "aqi", "uv", "daylight"}
+
+// Advise applies the rules to the given hours
+func (rules *AdviceRules) Advise(location string, hours []adviceHour, lang Lang) (*Advice, error) {
+	if len(hours) == 0 {
+		return nil, fmt.Errorf("no hourly forecast available")
+	}
+
+	advice := &Advice{
+		Location: location,
+		Lang:     lang,
+		From:     hours[0].Time,
+		To:       hours[len(hours)-1].Time.Add(time.Hour),
+		Clothing: ClothingAdvice{
+			ApparentMin: math.Inf(1),
+			ApparentMax: math.Inf(-1),
+		},
+		Activities: []ActivityAdvice{},
+		Summary:    []string{},
+	}
+
+	for _, hour := range hours {
+		advice.Clothing.ApparentMin = math.Min(advice.Clothing.ApparentMin, hour.ApparentTemperature)
+		advice.Clothing.ApparentMax = math.Max(advice.Clothing.ApparentMax, hour.ApparentTemperature)
+		advice.MaxUV = math.Max(advice.MaxUV, hour.UV)
+		if hour.AQI > advice.MaxAQI {
+			advice.MaxAQI = hour.AQI
+		}
+
+		umbrella := &advice.Umbrella
+		umbrella.TotalMM += hour.PrecipitationMM
+		if hour.PrecipitationProb > umbrella.MaxProbability {
+			umbrella.MaxProbability = hour.PrecipitationProb
+		}
+		if rules.Umbrella.needed(hour.PrecipitationProb, hour.PrecipitationMM) {
+			if !umbrella.Needed {
+				umbrella.From = hour.Time
+			}
+			umbrella.Needed = true
+		}
+	}
+	advice.Umbrella.TotalMM = roundTo(advice.Umbrella.TotalMM, 1)
+
+	for _, layer := range rules.Layers {
+		advice.Clothing.Layers = layer.Advice.in(lang)
+		if advice.Clothing.ApparentMin <= layer.MaxApparentTemperature {
+			break
+		}
+	}
+	advice.SunProtection = rules.SunProtectionUV > 0 && advice.MaxUV >= rules.SunProtectionUV
+	advice.Mask = rules.MaskAQI > 0 && advice.MaxAQI >= rules.MaskAQI
+
+	for _, rule := range rules.Activities {
+		advice.Activities = append(advice.Activities, rule.evaluate(hours, lang))
+	}
+
+	advice.Summary = adviceSummary(advice, lang)
+	return advice, nil
+}
+
+// evaluate finds the good windows for the activity
+func (rule ActivityRule) evaluate(hours []adviceHour, lang Lang) ActivityAdvice {
+	activity := ActivityAdvice{
+		ID:      rule.ID,
+		Name:    rule.Name.in(lang),
+		Windows: []AdviceWindow{},
+		Reasons: []string{},
+	}
+
+	failed := make(map[string]bool)
+	var window *AdviceWindow
+	for _, hour := range hours {
+		reasons := rule.check(hour)
+		if len(reasons) > 0 {
+			for _, reason := range reasons {
+				failed[reason] = true
+			}
+			window = nil
+			continue
+		}
+		if window == nil {
+			activity.Windows = append(activity.Windows, AdviceWindow{Start: hour.Time})
+			window = &activity.Windows[len(activity.Windows)-1]
+		}
+		window.End = hour.Time.Add(time.Hour)
+	}
+
+	activity.Recommended = len(activity.Windows) > 0
+	for _, reason := range adviceReasonOrder {
+		if failed[reason] {
+			activity.Reasons = append(activity.Reasons, adviceReasons[reason].in(lang))
+		}
+	}
+	return activity
+}
+
+// check returns the keys of adviceReasons the hour fails
+func (rule ActivityRule) check(hour adviceHour) []string {
+	var reasons []string
+	if rule.MinApparentTemperature != nil && hour.ApparentTemperature < *rule.MinApparentTemperature {
+		reasons = append(reasons, "cold")
+	}
+	if rule.MaxApparentTemperature != nil && hour.ApparentTemperature > *rule.MaxApparentTemperature {
+		reasons = append(reasons, "hot")
+	}
+	if rule.MaxWindSpeed != nil && hour.WindSpeed > *rule.MaxWindSpeed {
+		reasons = append(reasons, "wind")
+	}
+	if rule.MaxPrecipitationProbability != nil && hour.PrecipitationProb > *rule.MaxPrecipitationProbability {
+		reasons = append(reasons, "rain")
+	}
+	if rule.MaxAQI != nil && hour.AQI > *rule.MaxAQI {
+		reasons = append(reasons, "aqi")
+	}
+	if rule.MaxUV != nil && hour.UV > *rule.MaxUV {
+		reasons = append(reasons, "uv")
+	}
+	if rule.DaylightOnly && !hour.Daylight {
+		reasons = append(reasons, "daylight")
+	}
+	return reasons
+}
+
+// adviceSummary phrases the advice as short sentences for display or
+// notifications
+func adviceSummary(advice *Advice, lang Lang) []string {
+	clock := func(t time.Time) string { return t.Format("15:04") }
+	summary := []string{}
+
+	if advice.Umbrella.Needed {
+		summary = append(summary, fmt.Sprintf(localized{
+			"%s起可能降水（概率%d%%），请带伞",
+			"Rain possible from %s (%d%%), take an umbrella",
+		}.in(lang), clock(advice.Umbrella.From), advice.Umbrella.MaxProbability))
+	}
+	summary = append(summary, fmt.Sprintf(localized{
+		"体感%.0f~%.0f°C，建议%s",
+		"Feels like %.0f–%.0f°C: %s",
+	}.in(lang), advice.Clothing.ApparentMin, advice.Clothing.ApparentMax, advice.Clothing.Layers))
+	if advice.SunProtection {
+		summary = append(summary, fmt.Sprintf(localized{
+			"紫外线较强（%.0f），注意防晒",
+			"Strong UV (%.0f), wear sunscreen",
+		}.in(lang), advice.MaxUV))
+	}
+	if advice.Mask {
+		summary = append(summary, fmt.Sprintf(localized{
+			"空气质量%s（AQI %d），外出建议佩戴口罩",
+			"Air quality %s (AQI %d), consider a mask outdoors",
+		}.in(lang), aqiLevelText(advice.MaxAQI, lang), advice.MaxAQI))
+	}
+
+	for _, activity := range advice.Activities {
+		name := activity.Name
+		if lang == LangEN {
+			name = strings.ToLower(name)
+		}
+		if activity.Recommended {
+			var windows []string
+			for _, window := range activity.Windows {
+				windows = append(windows, clock(window.Start)+"–"+clock(window.End))
+			}
+			summary = append(summary, fmt.Sprintf(localized{
+				"适宜%s：%s",
+				"Good for %s: %s",
+			}.in(lang), name, strings.Join(windows, localized{"、", ", "}.in(lang))))
+			continue
+		}
+		summary = append(summary, fmt.Sprintf(localized{
+			"不建议%s：%s",
+			"Not a good time for %s: %s",
+		}.in(lang), name, strings.Join(activity.Reasons, localized{"、", ", "}.in(lang))))
+	}
+	return summary
+}
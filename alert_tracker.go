@@ -0,0 +1,260 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertChangeKind describes how an alert differs from the previous snapshot
+type AlertChangeKind string
+
+const (
+	AlertChangeIssued     AlertChangeKind = "issued"
+	AlertChangeUpgraded   AlertChangeKind = "upgraded"
+	AlertChangeDowngraded AlertChangeKind = "downgraded"
+	AlertChangeUpdated    AlertChangeKind = "updated"
+	AlertChangeLifted     AlertChangeKind = "lifted"
+)
+
+// maxAlertChanges bounds the change log kept in memory and on disk
+const maxAlertChanges = 5000
+
+// alertLiftMisses is how many consecutive responses an alert must be missing
+// from before it is reported lifted, so that a single empty or truncated
+// response does not lift and then reissue every alert
+const alertLiftMisses = 3
+
+// AlertChange is one entry of the alert change feed
+type AlertChange struct {
+	Seq        int64           `json:"seq"`
+	Location   string          `json:"location"`
+	Kind       AlertChangeKind `json:"kind"`
+	Alert      WeatherAlert    `json:"alert"`
+	Previous   *WeatherAlert   `json:"previous,omitempty"`
+	DetectedAt time.Time       `json:"detected_at"`
+}
+
+// AlertTracker remembers the active alerts of every location, keyed by
+// AlertID, and records what changed between snapshots
+type AlertTracker struct {
+	mu        sync.Mutex
+	path      string
+	Active    map[string]map[string]WeatherAlert `json:"active"`
+	Missing   map[string]map[string]int          `json:"missing,omitempty"` // consecutive responses without the alert
+	Changes   []AlertChange                      `json:"changes"`
+	LastSeq   int64                              `json:"last_seq"`
+	DroppedAt time.Time                          `json:"dropped_at,omitzero"` // detection time of the newest change dropped from the log
+}
+
+// newAlertTracker loads tracker state from path. An empty path keeps state in
+// memory only.
+func newAlertTracker(path string) (*AlertTracker, error) {
+	t := &AlertTracker{
+		path:    path,
+		Active:  make(map[string]map[string]WeatherAlert),
+		Missing: make(map[string]map[string]int),
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	if err := readJSONFile(path, t); err != nil {
+		return nil, err
+	}
+	if t.Active == nil {
+		t.Active = make(map[string]map[string]WeatherAlert)
+	}
+	if t.Missing == nil {
+		t.Missing = make(map[string]map[string]int)
+	}
+	return t, nil
+}
+
+// Observe compares the alerts currently in effect at location with the last
+// snapshot and returns the changes it recorded. An alert is lifted when the
+// provider marks it cancelled or after it has been missing from
+// alertLiftMisses consecutive responses.
+func (t *AlertTracker) Observe(location string, alerts []WeatherAlert) []AlertChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	previous := t.Active[location]
+	current := make(map[string]WeatherAlert, len(alerts))
+	cancelled := make(map[string]WeatherAlert)
+	var active []WeatherAlert
+	for _, alert := range alerts {
+		if alertCancelled(alert) {
+			cancelled[alert.ID] = alert
+			continue
+		}
+		current[alert.ID] = alert
+		active = append(active, alert)
+	}
+
+	var changes []AlertChange
+	record := func(kind AlertChangeKind, alert WeatherAlert, prev *WeatherAlert) {
+		t.LastSeq++
+		changes = append(changes, AlertChange{
+			Seq:        t.LastSeq,
+			Location:   location,
+			Kind:       kind,
+			Alert:      alert,
+			Previous:   prev,
+			DetectedAt: now,
+		})
+	}
+
+	// Alerts that are no longer in effect, sorted so that changes are
+	// recorded in a stable order
+	var absent []WeatherAlert
+	for id, alert := range previous {
+		if _, ok := current[id]; !ok {
+			absent = append(absent, alert)
+		}
+	}
+	sort.Slice(absent, func(i, j int) bool {
+		if absent[i].Type != absent[j].Type {
+			return absent[i].Type < absent[j].Type
+		}
+		return absent[i].ID < absent[j].ID
+	})
+
+	// A replacement issued under a new AlertID is reported as an upgrade or
+	// downgrade of a missing alert of the same type
+	replaceable := make(map[AlertType][]WeatherAlert)
+	for _, alert := range absent {
+		if _, ok := cancelled[alert.ID]; !ok {
+			replaceable[alert.Type] = append(replaceable[alert.Type], alert)
+		}
+	}
+	replaced := make(map[string]bool)
+
+	for _, alert := range active {
+		if prev, ok := previous[alert.ID]; ok {
+			if kind, changed := compareAlerts(prev, alert); changed {
+				record(kind, alert, &prev)
+			}
+			continue
+		}
+		if candidates := replaceable[alert.Type]; len(candidates) > 0 {
+			prev := candidates[0]
+			replaceable[alert.Type] = candidates[1:]
+			replaced[prev.ID] = true
+			kind, _ := compareAlerts(prev, alert)
+			record(kind, alert, &prev)
+			continue
+		}
+		record(AlertChangeIssued, alert, nil)
+	}
+
+	missing := make(map[string]int)
+	for _, alert := range absent {
+		if replaced[alert.ID] {
+			continue
+		}
+		if cancel, ok := cancelled[alert.ID]; ok {
+			record(AlertChangeLifted, cancel, nil)
+			continue
+		}
+		misses := t.Missing[location][alert.ID] + 1
+		if misses >= alertLiftMisses {
+			record(AlertChangeLifted, alert, nil)
+			continue
+		}
+		// Keep the alert until it has been missing long enough
+		current[alert.ID] = alert
+		missing[alert.ID] = misses
+	}
+
+	missingChanged := !maps.Equal(t.Missing[location], missing)
+	if len(current) == 0 {
+		delete(t.Active, location)
+	} else {
+		t.Active[location] = current
+	}
+	if len(missing) == 0 {
+		delete(t.Missing, location)
+	} else {
+		t.Missing[location] = missing
+	}
+
+	if len(changes) > 0 {
+		t.Changes = append(t.Changes, changes...)
+		if len(t.Changes) > maxAlertChanges {
+			dropped := len(t.Changes) - maxAlertChanges
+			t.DroppedAt = t.Changes[dropped-1].DetectedAt
+			t.Changes = t.Changes[dropped:]
+		}
+	}
+	if len(changes) > 0 || missingChanged {
+		if err := t.save(); err != nil {
+			log.Printf("Failed to save alert state: %v", err)
+		}
+	}
+
+	return changes
+}
+
+// alertCancelled reports whether the provider marked the alert as lifted
+func alertCancelled(alert WeatherAlert) bool {
+	return strings.Contains(alert.Status, "解除")
+}
+
+// ChangesSince returns the changes after seq, or detected after since when
+// it is set, optionally restricted to one location, and the sequence number
+// of the newest recorded change to resume from. truncated reports that some
+// changes after the cursor are no longer kept, or that the cursor is ahead
+// of the log, as after a reset of the data directory; clients should then
+// reload the current alerts.
+func (t *AlertTracker) ChangesSince(location string, seq int64, since time.Time) (changes []AlertChange, next int64, truncated bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if since.IsZero() {
+		oldest := t.LastSeq + 1
+		if len(t.Changes) > 0 {
+			oldest = t.Changes[0].Seq
+		}
+		truncated = seq < oldest-1 || seq > t.LastSeq
+	} else {
+		truncated = !t.DroppedAt.IsZero() && !t.DroppedAt.Before(since)
+	}
+
+	result := []AlertChange{}
+	for _, change := range t.Changes {
+		if change.Seq <= seq || change.DetectedAt.Before(since) {
+			continue
+		}
+		if location != "" && change.Location != location {
+			continue
+		}
+		result = append(result, change)
+	}
+	return result, t.LastSeq, truncated
+}
+
+// compareAlerts classifies the difference between two versions of an alert
+func compareAlerts(prev, next WeatherAlert) (AlertChangeKind, bool) {
+	switch {
+	case next.Severity > prev.Severity:
+		return AlertChangeUpgraded, true
+	case next.Severity < prev.Severity:
+		return AlertChangeDowngraded, true
+	case next.Title != prev.Title || next.Description != prev.Description || next.Status != prev.Status:
+		return AlertChangeUpdated, true
+	}
+	return AlertChangeUpdated, false
+}
+
+// save writes the tracker state atomically. Callers must hold t.mu.
+func (t *AlertTracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+	return writeJSONFile(t.path, t)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func trackerAlert(id, status string, typ AlertType, severity AlertSeverity) WeatherAlert {
+	return WeatherAlert{ID: id, Title: fmt.Sprintf("%s %d", typ, severity), Type: typ, Severity: severity, Status: status}
+}
+
+// changeKinds summarizes changes as "kind:id" for comparison
+func changeKinds(changes []AlertChange) []string {
+	var result []string
+	for _, change := range changes {
+		result = append(result, string(change.Kind)+":"+change.Alert.ID)
+	}
+	return result
+}
+
+func TestAlertTrackerObserve(t *testing.T) {
+	rain := trackerAlert("r1", "预警中", AlertTypeRainstorm, AlertSeverityModerate)
+	rainUpdated := rain
+	rainUpdated.Description = "更新"
+	rainOrange := trackerAlert("r2", "预警中", AlertTypeRainstorm, AlertSeveritySevere)
+	rainBlue := trackerAlert("r3", "预警中", AlertTypeRainstorm, AlertSeverityMinor)
+	rainBlueLifted := rainBlue
+	rainBlueLifted.Status = "解除"
+	fog := trackerAlert("f1", "预警中", AlertTypeFog, AlertSeverityMinor)
+	gale := trackerAlert("g1", "预警中", AlertTypeGale, AlertSeverityMinor)
+
+	steps := []struct {
+		name   string
+		alerts []WeatherAlert
+		want   []string
+	}{
+		{"issued", []WeatherAlert{rain, fog}, []string{"issued:r1", "issued:f1"}},
+		{"unchanged", []WeatherAlert{rain, fog}, nil},
+		{"updated text", []WeatherAlert{rainUpdated, fog}, []string{"updated:r1"}},
+		{"reissued under a new ID", []WeatherAlert{rainOrange, fog}, []string{"upgraded:r2"}},
+		{"downgraded under a new ID", []WeatherAlert{rainBlue, fog}, []string{"downgraded:r3"}},
+		{"fog missing once", []WeatherAlert{rainBlue}, nil},
+		{"fog back", []WeatherAlert{rainBlue, fog}, nil},
+		{"fog missing again", []WeatherAlert{rainBlue}, nil},
+		{"fog missing twice", []WeatherAlert{rainBlue}, nil},
+		{"fog missing three times", []WeatherAlert{rainBlue, gale}, []string{"issued:g1", "lifted:f1"}},
+		{"cancelled by the provider", []WeatherAlert{rainBlueLifted, gale}, []string{"lifted:r3"}},
+		{"empty responses", nil, nil},
+		{"still debouncing", nil, nil},
+		{"lifted", nil, []string{"lifted:g1"}},
+		{"nothing left", nil, nil},
+	}
+
+	tracker, err := newAlertTracker("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		got := changeKinds(tracker.Observe("loc", step.alerts))
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%s: changes = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if len(tracker.Active) != 0 || len(tracker.Missing) != 0 {
+		t.Errorf("state left after every alert was lifted: active %v, missing %v", tracker.Active, tracker.Missing)
+	}
+}
+
+func TestAlertTrackerReplacementPrevious(t *testing.T) {
+	tracker, err := newAlertTracker("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	yellow := trackerAlert("r1", "预警中", AlertTypeRainstorm, AlertSeverityModerate)
+	red := trackerAlert("r2", "预警中", AlertTypeRainstorm, AlertSeverityExtreme)
+	tracker.Observe("loc", []WeatherAlert{yellow})
+	changes := tracker.Observe("loc", []WeatherAlert{red})
+	if len(changes) != 1 || changes[0].Previous == nil || changes[0].Previous.ID != "r1" {
+		t.Fatalf("changes = %+v, want an upgrade from r1", changes)
+	}
+	if _, ok := tracker.Active["loc"]["r1"]; ok {
+		t.Error("replaced alert r1 is still active")
+	}
+}
+
+func TestAlertTrackerChangesSince(t *testing.T) {
+	tracker, err := newAlertTracker("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Observe("a", []WeatherAlert{trackerAlert("a1", "预警中", AlertTypeFog, AlertSeverityMinor)})
+	tracker.Observe("b", []WeatherAlert{trackerAlert("b1", "预警中", AlertTypeFog, AlertSeverityMinor)})
+	middle := time.Now()
+	time.Sleep(time.Millisecond)
+	tracker.Observe("a", []WeatherAlert{trackerAlert("a2", "预警中", AlertTypeGale, AlertSeverityMinor), trackerAlert("a1", "预警中", AlertTypeFog, AlertSeverityMinor)})
+
+	tests := []struct {
+		name      string
+		location  string
+		seq       int64
+		since     time.Time
+		want      []string
+		truncated bool
+	}{
+		{"everything", "", 0, time.Time{}, []string{"issued:a1", "issued:b1", "issued:a2"}, false},
+		{"after seq", "", 1, time.Time{}, []string{"issued:b1", "issued:a2"}, false},
+		{"up to date", "", 3, time.Time{}, nil, false},
+		{"one location", "a", 0, time.Time{}, []string{"issued:a1", "issued:a2"}, false},
+		{"after time", "", 0, middle, []string{"issued:a2"}, false},
+		{"cursor ahead of the log", "", 7, time.Time{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, next, truncated := tracker.ChangesSince(tt.location, tt.seq, tt.since)
+			if got := changeKinds(changes); !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+			if next != 3 {
+				t.Errorf("next = %d, want 3", next)
+			}
+			if truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestAlertTrackerChangesSinceTruncated(t *testing.T) {
+	tracker, err := newAlertTracker("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := range maxAlertChanges + 10 {
+		tracker.Observe(fmt.Sprint("loc", i), []WeatherAlert{trackerAlert(fmt.Sprint("a", i), "预警中", AlertTypeFog, AlertSeverityMinor)})
+	}
+
+	if len(tracker.Changes) != maxAlertChanges || tracker.Changes[0].Seq != 11 {
+		t.Fatalf("kept %d changes from seq %d, want %d from 11", len(tracker.Changes), tracker.Changes[0].Seq, maxAlertChanges)
+	}
+	for _, tt := range []struct {
+		seq       int64
+		since     time.Time
+		truncated bool
+	}{
+		{0, time.Time{}, true},
+		{9, time.Time{}, true},
+		{10, time.Time{}, false},
+		{5000, time.Time{}, false},
+		{0, start, true},
+		{0, time.Now().Add(time.Second), false},
+	} {
+		if _, _, truncated := tracker.ChangesSince("", tt.seq, tt.since); truncated != tt.truncated {
+			t.Errorf("ChangesSince(%d, %v): truncated = %v, want %v", tt.seq, tt.since, truncated, tt.truncated)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AlertType is the hazard an alert warns about. Values match the first two
+// digits of the national alert code, e.g. "0201" is a blue rainstorm alert.
+type AlertType int
+
+const (
+	AlertTypeUnknown AlertType = iota
+	AlertTypeTyphoon
+	AlertTypeRainstorm
+	AlertTypeSnowstorm
+	AlertTypeColdWave
+	AlertTypeGale
+	AlertTypeSandstorm
+	AlertTypeHeatWave
+	AlertTypeDrought
+	AlertTypeLightning
+	AlertTypeHail
+	AlertTypeFrost
+	AlertTypeFog
+	AlertTypeHaze
+	AlertTypeRoadIcing
+	AlertTypeForestFire
+	AlertTypeThunderGale
+	AlertTypeSpringDust
+	AlertTypeDust
+)
+
+var alertTypeInfo = map[AlertType]struct {
+	slug string
+	name localized
+}{
+	AlertTypeUnknown:     {"unknown", localized{"未知", "Unknown"}},
+	AlertTypeTyphoon:     {"typhoon", localized{"台风", "Typhoon"}},
+	AlertTypeRainstorm:   {"rainstorm", localized{"暴雨", "Rainstorm"}},
+	AlertTypeSnowstorm:   {"snowstorm", localized{"暴雪", "Snowstorm"}},
+	AlertTypeColdWave:    {"cold_wave", localized{"寒潮", "Cold wave"}},
+	AlertTypeGale:        {"gale", localized{"大风", "Gale"}},
+	AlertTypeSandstorm:   {"sandstorm", localized{"沙尘暴", "Sandstorm"}},
+	AlertTypeHeatWave:    {"heat_wave", localized{"高温", "Heat wave"}},
+	AlertTypeDrought:     {"drought", localized{"干旱", "Drought"}},
+	AlertTypeLightning:   {"lightning", localized{"雷电", "Lightning"}},
+	AlertTypeHail:        {"hail", localized{"冰雹", "Hail"}},
+	AlertTypeFrost:       {"frost", localized{"霜冻", "Frost"}},
+	AlertTypeFog:         {"fog", localized{"大雾", "Heavy fog"}},
+	AlertTypeHaze:        {"haze", localized{"霾", "Haze"}},
+	AlertTypeRoadIcing:   {"road_icing", localized{"道路结冰", "Road icing"}},
+	AlertTypeForestFire:  {"forest_fire", localized{"森林火灾", "Forest fire"}},
+	AlertTypeThunderGale: {"thunderstorm_gale", localized{"雷雨大风", "Thunderstorm gale"}},
+	AlertTypeSpringDust:  {"spring_dust", localized{"春季沙尘天气趋势", "Spring dust trend"}},
+	AlertTypeDust:        {"dust", localized{"沙尘", "Dust"}},
+}
+
+// alertTypeAliases are title keywords that are not the canonical type name
+var alertTypeAliases = map[string]AlertType{
+	"森林火险":   AlertTypeForestFire,
+	"森林草原火险": AlertTypeForestFire,
+	"雷暴大风":   AlertTypeThunderGale,
+	"雾":      AlertTypeFog,
+}
+
+// alertTypeKeywords lists every title keyword, longest first, so that
+// "雷雨大风" wins over "大风" and "沙尘暴" over "沙尘"
+var alertTypeKeywords = func() []string {
+	var keywords []string
+	for t, info := range alertTypeInfo {
+		if t != AlertTypeUnknown {
+			keywords = append(keywords, info.name.zh)
+		}
+	}
+	for keyword := range alertTypeAliases {
+		keywords = append(keywords, keyword)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if len(keywords[i]) != len(keywords[j]) {
+			return len(keywords[i]) > len(keywords[j])
+		}
+		return keywords[i] < keywords[j]
+	})
+	return keywords
+}()
+
+func (t AlertType) String() string {
+	if info, ok := alertTypeInfo[t]; ok {
+		return info.slug
+	}
+	return alertTypeInfo[AlertTypeUnknown].slug
+}
+
+// Name returns the localized hazard name, e.g. "暴雨" or "Rainstorm"
+func (t AlertType) Name(lang Lang) string {
+	if info, ok := alertTypeInfo[t]; ok {
+		return info.name.in(lang)
+	}
+	return alertTypeInfo[AlertTypeUnknown].name.in(lang)
+}
+
+func (t AlertType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *AlertType) UnmarshalText(text []byte) error {
+	for candidate, info := range alertTypeInfo {
+		if info.slug == string(text) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown alert type %q", text)
+}
+
+// AlertColor is the color grade of an alert. Values match the last two digits
+// of the national alert code.
+type AlertColor int
+
+const (
+	AlertColorUnknown AlertColor = iota
+	AlertColorBlue
+	AlertColorYellow
+	AlertColorOrange
+	AlertColorRed
+)
+
+var alertColorInfo = map[AlertColor]struct {
+	slug string
+	name localized
+}{
+	AlertColorUnknown: {"unknown", localized{"未知", "Unknown"}},
+	AlertColorBlue:    {"blue", localized{"蓝色", "Blue"}},
+	AlertColorYellow:  {"yellow", localized{"黄色", "Yellow"}},
+	AlertColorOrange:  {"orange", localized{"橙色", "Orange"}},
+	AlertColorRed:     {"red", localized{"红色", "Red"}},
+}
+
+func (c AlertColor) String() string {
+	if info, ok := alertColorInfo[c]; ok {
+		return info.slug
+	}
+	return alertColorInfo[AlertColorUnknown].slug
+}
+
+// Name returns the localized color name, e.g. "橙色" or "Orange"
+func (c AlertColor) Name(lang Lang) string {
+	if info, ok := alertColorInfo[c]; ok {
+		return info.name.in(lang)
+	}
+	return alertColorInfo[AlertColorUnknown].name.in(lang)
+}
+
+func (c AlertColor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *AlertColor) UnmarshalText(text []byte) error {
+	for candidate, info := range alertColorInfo {
+		if info.slug == string(text) {
+			*c = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown alert color %q", text)
+}
+
+// AlertSeverity is the official response grade, from Ⅳ (minor) up to
+// Ⅰ (extreme). Higher values are more severe.
+type AlertSeverity int
+
+const (
+	AlertSeverityUnknown AlertSeverity = iota
+	AlertSeverityMinor
+	AlertSeverityModerate
+	AlertSeveritySevere
+	AlertSeverityExtreme
+)
+
+var alertSeverityInfo = map[AlertSeverity]struct {
+	slug  string
+	grade string
+	name  localized
+}{
+	AlertSeverityUnknown:  {"unknown", "", localized{"未知", "Unknown"}},
+	AlertSeverityMinor:    {"minor", "Ⅳ级", localized{"一般", "Minor"}},
+	AlertSeverityModerate: {"moderate", "Ⅲ级", localized{"较重", "Moderate"}},
+	AlertSeveritySevere:   {"severe", "Ⅱ级", localized{"严重", "Severe"}},
+	AlertSeverityExtreme:  {"extreme", "Ⅰ级", localized{"特别严重", "Extreme"}},
+}
+
+func (s AlertSeverity) String() string {
+	if info, ok := alertSeverityInfo[s]; ok {
+		return info.slug
+	}
+	return alertSeverityInfo[AlertSeverityUnknown].slug
+}
+
+// Name returns the localized severity name, e.g. "较重" or "Moderate"
+func (s AlertSeverity) Name(lang Lang) string {
+	if info, ok := alertSeverityInfo[s]; ok {
+		return info.name.in(lang)
+	}
+	return alertSeverityInfo[AlertSeverityUnknown].name.in(lang)
+}
+
+// Label returns the grade as printed in alert titles, e.g. "Ⅲ级/较重"
+func (s AlertSeverity) Label(lang Lang) string {
+	info, ok := alertSeverityInfo[s]
+	if !ok || info.grade == "" {
+		return s.Name(lang)
+	}
+	if lang == LangEN {
+		return fmt.Sprintf("Level %s/%s", romanNumerals.Replace(strings.TrimSuffix(info.grade, "级")), info.name.en)
+	}
+	return info.grade + "/" + info.name.zh
+}
+
+func (s AlertSeverity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *AlertSeverity) UnmarshalText(text []byte) error {
+	for candidate, info := range alertSeverityInfo {
+		if info.slug == string(text) {
+			*s = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown alert severity %q", text)
+}
+
+var romanNumerals = strings.NewReplacer("Ⅰ", "I", "Ⅱ", "II", "Ⅲ", "III", "Ⅳ", "IV")
+
+// severityForColor maps each color grade to its response grade
+func severityForColor(c AlertColor) AlertSeverity {
+	switch c {
+	case AlertColorBlue:
+		return AlertSeverityMinor
+	case AlertColorYellow:
+		return AlertSeverityModerate
+	case AlertColorOrange:
+		return AlertSeveritySevere
+	case AlertColorRed:
+		return AlertSeverityExtreme
+	}
+	return AlertSeverityUnknown
+}
+
+// AlertClass is the structured form of an alert's code and title
+type AlertClass struct {
+	Type     AlertType
+	Color    AlertColor
+	Severity AlertSeverity
+}
+
+// classifyAlert combines the numeric code and the title. The code is
+// authoritative; the title fills in whatever the code leaves unknown. The
+// severity follows the code's color when it has one, then the grade in the
+// title, then the title's color.
+func classifyAlert(code, title string) AlertClass {
+	fromCode := parseAlertCode(code)
+	fromTitle := parseAlertTitle(title)
+
+	class := fromCode
+	if class.Type == AlertTypeUnknown {
+		class.Type = fromTitle.Type
+	}
+	if class.Color == AlertColorUnknown {
+		class.Color = fromTitle.Color
+	}
+	if class.Severity == AlertSeverityUnknown {
+		class.Severity = fromTitle.Severity
+	}
+	if class.Severity == AlertSeverityUnknown {
+		class.Severity = severityForColor(class.Color)
+	}
+	return class
+}
+
+// parseAlertCode decodes a 4-digit code such as "0903" (orange lightning).
+// Malformed or unknown codes yield unknown fields.
+func parseAlertCode(code string) AlertClass {
+	code = strings.TrimSpace(code)
+	if len(code) != 4 {
+		return AlertClass{}
+	}
+	typeNum, err := strconv.Atoi(code[:2])
+	if err != nil {
+		return AlertClass{}
+	}
+	colorNum, err := strconv.Atoi(code[2:])
+	if err != nil {
+		return AlertClass{}
+	}
+
+	var class AlertClass
+	if _, ok := alertTypeInfo[AlertType(typeNum)]; ok {
+		class.Type = AlertType(typeNum)
+	}
+	if _, ok := alertColorInfo[AlertColor(colorNum)]; ok {
+		class.Color = AlertColor(colorNum)
+	}
+	class.Severity = severityForColor(class.Color)
+	return class
+}
+
+// parseAlertTitle extracts what it can from titles like
+// "台风蓝色预警[Ⅳ级/一般]" or "杭州市气象台发布暴雨黄色预警信号"
+func parseAlertTitle(title string) AlertClass {
+	var class AlertClass
+
+	head, grade := splitAlertTitle(title)
+
+	for _, keyword := range alertTypeKeywords {
+		if strings.Contains(head, keyword) {
+			if t, ok := alertTypeAliases[keyword]; ok {
+				class.Type = t
+			} else {
+				class.Type = alertTypeByName(keyword)
+			}
+			break
+		}
+	}
+
+	for c, info := range alertColorInfo {
+		if c != AlertColorUnknown && strings.Contains(head, info.name.zh) {
+			class.Color = c
+			break
+		}
+	}
+
+	class.Severity = parseAlertGrade(grade)
+	return class
+}
+
+// splitAlertTitle separates the bracketed grade, if any, from the rest of
+// the title. Both ASCII and full-width brackets are accepted.
+func splitAlertTitle(title string) (head, grade string) {
+	title = strings.TrimSpace(title)
+	for _, pair := range [][2]string{{"[", "]"}, {"【", "】"}, {"(", ")"}, {"（", "）"}} {
+		start := strings.LastIndex(title, pair[0])
+		if start == -1 {
+			continue
+		}
+		end := strings.Index(title[start:], pair[1])
+		if end == -1 {
+			continue
+		}
+		return title[:start] + title[start+end+len(pair[1]):], title[start+len(pair[0]) : start+end]
+	}
+	return title, ""
+}
+
+// parseAlertGrade reads grades such as "Ⅳ级/一般", "III级", "二级" or "特别严重"
+func parseAlertGrade(grade string) AlertSeverity {
+	grade = strings.TrimSpace(grade)
+	if grade == "" {
+		return AlertSeverityUnknown
+	}
+
+	numeral, word, _ := strings.Cut(grade, "/")
+	numeral = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(numeral), "级"))
+	switch strings.ToUpper(numeral) {
+	case "Ⅰ", "I", "1", "一":
+		return AlertSeverityExtreme
+	case "Ⅱ", "II", "2", "二":
+		return AlertSeveritySevere
+	case "Ⅲ", "III", "3", "三":
+		return AlertSeverityModerate
+	case "Ⅳ", "IV", "4", "四":
+		return AlertSeverityMinor
+	}
+
+	if word == "" {
+		word = grade
+	}
+	switch {
+	case strings.Contains(word, "特别严重"):
+		return AlertSeverityExtreme
+	case strings.Contains(word, "严重"):
+		return AlertSeveritySevere
+	case strings.Contains(word, "较重"):
+		return AlertSeverityModerate
+	case strings.Contains(word, "一般"):
+		return AlertSeverityMinor
+	}
+	return AlertSeverityUnknown
+}
+
+func alertTypeByName(name string) AlertType {
+	for t, info := range alertTypeInfo {
+		if info.name.zh == name {
+			return t
+		}
+	}
+	return AlertTypeUnknown
+}
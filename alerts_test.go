@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func TestClassifyAlert(t *testing.T) {
+	tests := []struct {
+		code     string
+		title    string
+		typ      AlertType
+		color    AlertColor
+		severity AlertSeverity
+	}{
+		// one row per hazard type, cycling through the colors
+		{"0101", "台风蓝色预警[Ⅳ级/一般]", AlertTypeTyphoon, AlertColorBlue, AlertSeverityMinor},
+		{"0202", "杭州市气象台发布暴雨黄色预警信号", AlertTypeRainstorm, AlertColorYellow, AlertSeverityModerate},
+		{"0303", "哈尔滨市气象台发布暴雪橙色预警[Ⅱ级/严重]", AlertTypeSnowstorm, AlertColorOrange, AlertSeveritySevere},
+		{"0404", "内蒙古自治区气象台发布寒潮红色预警信号[Ⅰ级/特别严重]", AlertTypeColdWave, AlertColorRed, AlertSeverityExtreme},
+		{"0501", "青岛市气象台发布大风蓝色预警[Ⅳ级/一般]", AlertTypeGale, AlertColorBlue, AlertSeverityMinor},
+		{"0602", "阿克苏地区气象台发布沙尘暴黄色预警[Ⅲ级/较重]", AlertTypeSandstorm, AlertColorYellow, AlertSeverityModerate},
+		{"0703", "重庆市气象台发布高温橙色预警[Ⅱ级/严重]", AlertTypeHeatWave, AlertColorOrange, AlertSeveritySevere},
+		{"0804", "云南省气象台发布干旱红色预警[Ⅰ级/特别严重]", AlertTypeDrought, AlertColorRed, AlertSeverityExtreme},
+		{"0903", "深圳市气象台发布雷电橙色预警信号", AlertTypeLightning, AlertColorOrange, AlertSeveritySevere},
+		{"1003", "贵阳市气象台发布冰雹橙色预警[Ⅱ级/严重]", AlertTypeHail, AlertColorOrange, AlertSeveritySevere},
+		{"1101", "郑州市气象台发布霜冻蓝色预警[Ⅳ级/一般]", AlertTypeFrost, AlertColorBlue, AlertSeverityMinor},
+		{"1202", "南京市气象台发布大雾黄色预警[Ⅲ级/较重]", AlertTypeFog, AlertColorYellow, AlertSeverityModerate},
+		{"1303", "石家庄市气象台发布霾橙色预警[Ⅱ级/严重]", AlertTypeHaze, AlertColorOrange, AlertSeveritySevere},
+		{"1404", "长春市气象台发布道路结冰红色预警[Ⅰ级/特别严重]", AlertTypeRoadIcing, AlertColorRed, AlertSeverityExtreme},
+		{"1502", "大兴安岭地区气象台发布森林火险黄色预警", AlertTypeForestFire, AlertColorYellow, AlertSeverityModerate},
+		{"1603", "广州市气象台发布雷雨大风橙色预警[Ⅱ级/严重]", AlertTypeThunderGale, AlertColorOrange, AlertSeveritySevere},
+		{"1701", "中央气象台发布春季沙尘天气趋势蓝色预警", AlertTypeSpringDust, AlertColorBlue, AlertSeverityMinor},
+		{"1802", "兰州市气象台发布沙尘黄色预警[Ⅲ级/较重]", AlertTypeDust, AlertColorYellow, AlertSeverityModerate},
+
+		// no code: everything comes from the title
+		{"", "武汉市气象台发布暴雨红色预警信号", AlertTypeRainstorm, AlertColorRed, AlertSeverityExtreme},
+		{"", "雷暴大风黄色预警", AlertTypeThunderGale, AlertColorYellow, AlertSeverityModerate},
+		{"", "森林草原火险橙色预警", AlertTypeForestFire, AlertColorOrange, AlertSeveritySevere},
+		{"", "平潭县气象台发布雾蓝色预警", AlertTypeFog, AlertColorBlue, AlertSeverityMinor},
+
+		// grade suffixes in Roman, ASCII and Chinese numerals and brackets
+		{"", "台风预警[III级]", AlertTypeTyphoon, AlertColorUnknown, AlertSeverityModerate},
+		{"", "台风预警【Ⅱ级/严重】", AlertTypeTyphoon, AlertColorUnknown, AlertSeveritySevere},
+		{"", "暴雨预警（二级）", AlertTypeRainstorm, AlertColorUnknown, AlertSeveritySevere},
+		{"", "暴雨预警(一级)", AlertTypeRainstorm, AlertColorUnknown, AlertSeverityExtreme},
+		{"", "高温预警[四级]", AlertTypeHeatWave, AlertColorUnknown, AlertSeverityMinor},
+		{"", "寒潮预警[IV级]", AlertTypeColdWave, AlertColorUnknown, AlertSeverityMinor},
+		{"", "寒潮预警[特别严重]", AlertTypeColdWave, AlertColorUnknown, AlertSeverityExtreme},
+
+		// malformed or unknown codes fall back to the title
+		{"09x3", "雷电橙色预警", AlertTypeLightning, AlertColorOrange, AlertSeveritySevere},
+		{"092", "雷电黄色预警", AlertTypeLightning, AlertColorYellow, AlertSeverityModerate},
+		{"9902", "冰雹黄色预警", AlertTypeHail, AlertColorYellow, AlertSeverityModerate},
+		{"0209", "暴雨橙色预警", AlertTypeRainstorm, AlertColorOrange, AlertSeveritySevere},
+		{"xx", "预警信号", AlertTypeUnknown, AlertColorUnknown, AlertSeverityUnknown},
+
+		// the code wins when the title disagrees
+		{"0201", "暴雨黄色预警[Ⅲ级/较重]", AlertTypeRainstorm, AlertColorBlue, AlertSeverityMinor},
+		{"0904", "大风蓝色预警", AlertTypeLightning, AlertColorRed, AlertSeverityExtreme},
+		{"0700", "高温橙色预警[Ⅱ级/严重]", AlertTypeHeatWave, AlertColorOrange, AlertSeveritySevere},
+	}
+
+	for _, tt := range tests {
+		got := classifyAlert(tt.code, tt.title)
+		if got.Type != tt.typ || got.Color != tt.color || got.Severity != tt.severity {
+			t.Errorf("classifyAlert(%q, %q) = %v/%v/%v, want %v/%v/%v",
+				tt.code, tt.title, got.Type, got.Color, got.Severity, tt.typ, tt.color, tt.severity)
+		}
+	}
+}
+
+func TestParseAlertCode(t *testing.T) {
+	tests := []struct {
+		code  string
+		typ   AlertType
+		color AlertColor
+	}{
+		{"0903", AlertTypeLightning, AlertColorOrange},
+		{" 1404 ", AlertTypeRoadIcing, AlertColorRed},
+		{"1900", AlertTypeUnknown, AlertColorUnknown},
+		{"0a01", AlertTypeUnknown, AlertColorUnknown},
+		{"02011", AlertTypeUnknown, AlertColorUnknown},
+		{"", AlertTypeUnknown, AlertColorUnknown},
+	}
+
+	for _, tt := range tests {
+		got := parseAlertCode(tt.code)
+		if got.Type != tt.typ || got.Color != tt.color || got.Severity != severityForColor(tt.color) {
+			t.Errorf("parseAlertCode(%q) = %v/%v/%v, want %v/%v", tt.code, got.Type, got.Color, got.Severity, tt.typ, tt.color)
+		}
+	}
+}
+
+func TestAlertColorUnknownName(t *testing.T) {
+	if got := AlertColorUnknown.Name(LangZH); got != "未知" {
+		t.Errorf("AlertColorUnknown.Name(zh) = %q, want 未知", got)
+	}
+	if got := AlertColorUnknown.Name(LangEN); got != "Unknown" {
+		t.Errorf("AlertColorUnknown.Name(en) = %q, want Unknown", got)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Low-precision sun and moon formulas after Meeus, "Astronomical
+// Algorithms", as popularized by the suncalc library. Times are accurate to
+// about a minute, which is plenty for a weather app.
+
+const (
+	rad        = math.Pi / 180
+	julian1970 = 2440588.0
+	julian2000 = 2451545.0
+	// obliquity of the Earth
+	obliquity = rad * 23.4397
+	// julianOffset corrects the transit approximation
+	julianOffset = 0.0009
+	// sunDistanceKm is the mean distance from the Earth to the Sun
+	sunDistanceKm = 149598000
+)
+
+// Sun altitudes (degrees) that define each event
+const (
+	altitudeSunrise      = -0.833
+	altitudeCivil        = -6
+	altitudeNautical     = -12
+	altitudeAstronomical = -18
+	altitudeBlueHourEnd  = -4
+	altitudeGoldenHour   = 6
+)
+
+func toJulian(t time.Time) float64 {
+	return float64(t.UnixMilli())/86400000 - 0.5 + julian1970
+}
+
+func fromJulian(j float64) time.Time {
+	return time.UnixMilli(int64(math.Round((j + 0.5 - julian1970) * 86400000)))
+}
+
+func toDays(t time.Time) float64 {
+	return toJulian(t) - julian2000
+}
+
+func rightAscension(l, b float64) float64 {
+	return math.Atan2(math.Sin(l)*math.Cos(obliquity)-math.Tan(b)*math.Sin(obliquity), math.Cos(l))
+}
+
+func declination(l, b float64) float64 {
+	return math.Asin(math.Sin(b)*math.Cos(obliquity) + math.Cos(b)*math.Sin(obliquity)*math.Sin(l))
+}
+
+func altitude(h, phi, dec float64) float64 {
+	return math.Asin(math.Sin(phi)*math.Sin(dec) + math.Cos(phi)*math.Cos(dec)*math.Cos(h))
+}
+
+func siderealTime(d, lw float64) float64 {
+	return rad*(280.16+360.9856235*d) - lw
+}
+
+// astroRefraction approximates atmospheric refraction at altitude h
+func astroRefraction(h float64) float64 {
+	if h < 0 {
+		h = 0
+	}
+	return 0.0002967 / math.Tan(h+0.00312536/(h+0.08901179))
+}
+
+func solarMeanAnomaly(d float64) float64 {
+	return rad * (357.5291 + 0.98560028*d)
+}
+
+func eclipticLongitude(m float64) float64 {
+	center := rad * (1.9148*math.Sin(m) + 0.02*math.Sin(2*m) + 0.0003*math.Sin(3*m))
+	perihelion := rad * 102.9372
+	return m + center + perihelion + math.Pi
+}
+
+// sunCoords returns the sun's declination and right ascension
+func sunCoords(d float64) (dec, ra float64) {
+	l := eclipticLongitude(solarMeanAnomaly(d))
+	return declination(l, 0), rightAscension(l, 0)
+}
+
+// solarDay holds the quantities shared by all sun events of a day
+type solarDay struct {
+	lw, phi, dec, n, m, l float64
+	noon                  float64 // Julian date of solar noon
+}
+
+func newSolarDay(t time.Time, lat, lng float64) solarDay {
+	lw := rad * -lng
+	d := toDays(t)
+	n := math.Round(d - julianOffset - lw/(2*math.Pi))
+	ds := julianOffset + lw/(2*math.Pi) + n
+	m := solarMeanAnomaly(ds)
+	l := eclipticLongitude(m)
+	return solarDay{
+		lw:   lw,
+		phi:  rad * lat,
+		dec:  declination(l, 0),
+		n:    n,
+		m:    m,
+		l:    l,
+		noon: julian2000 + ds + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*l),
+	}
+}
+
+// times returns when the sun crosses altitude h (degrees) rising and
+// setting, or ok=false when it stays above or below all day
+func (s solarDay) times(h float64) (rise, set time.Time, ok bool) {
+	cosW := (math.Sin(rad*h) - math.Sin(s.phi)*math.Sin(s.dec)) / (math.Cos(s.phi) * math.Cos(s.dec))
+	if cosW < -1 || cosW > 1 {
+		return rise, set, false
+	}
+	w := math.Acos(cosW)
+	a := julianOffset + (w+s.lw)/(2*math.Pi) + s.n
+	jset := julian2000 + a + 0.0053*math.Sin(s.m) - 0.0069*math.Sin(2*s.l)
+	jrise := s.noon - (jset - s.noon)
+	return fromJulian(jrise), fromJulian(jset), true
+}
+
+// moonCoords returns the moon's declination, right ascension and distance
+// in km
+func moonCoords(d float64) (dec, ra, dist float64) {
+	l := rad * (218.316 + 13.176396*d)
+	m := rad * (134.963 + 13.064993*d)
+	f := rad * (93.272 + 13.229350*d)
+
+	lng := l + rad*6.289*math.Sin(m)
+	lat := rad * 5.128 * math.Sin(f)
+	return declination(lng, lat), rightAscension(lng, lat), 385001 - 20905*math.Cos(m)
+}
+
+// moonAltitude returns the moon's apparent altitude in radians
+func moonAltitude(t time.Time, lat, lng float64) float64 {
+	d := toDays(t)
+	dec, ra, _ := moonCoords(d)
+	h := altitude(siderealTime(d, rad*-lng)-ra, rad*lat, dec)
+	return h + astroRefraction(h)
+}
+
+// moonIllumination returns the illuminated fraction and the phase, where
+// 0 is new moon, 0.25 first quarter, 0.5 full and 0.75 last quarter
+func moonIllumination(t time.Time) (fraction, phase float64) {
+	d := toDays(t)
+	sdec, sra := sunCoords(d)
+	mdec, mra, mdist := moonCoords(d)
+
+	elongation := math.Acos(math.Sin(sdec)*math.Sin(mdec) + math.Cos(sdec)*math.Cos(mdec)*math.Cos(sra-mra))
+	inc := math.Atan2(sunDistanceKm*math.Sin(elongation), mdist-sunDistanceKm*math.Cos(elongation))
+	angle := math.Atan2(
+		math.Cos(sdec)*math.Sin(sra-mra),
+		math.Sin(sdec)*math.Cos(mdec)-math.Cos(sdec)*math.Sin(mdec)*math.Cos(sra-mra),
+	)
+
+	sign := 1.0
+	if angle < 0 {
+		sign = -1
+	}
+	return (1 + math.Cos(inc)) / 2, 0.5 + 0.5*inc*sign/math.Pi
+}
+
+// moonTimes finds moonrise and moonset in the 24 hours from start by
+// fitting a parabola through altitudes sampled every hour. A nil result
+// means the event does not happen that day.
+func moonTimes(start time.Time, lat, lng float64) (rise, set *time.Time, alwaysUp, alwaysDown bool) {
+	const hc = 0.133 * rad
+	at := func(hours float64) time.Time {
+		return start.Add(time.Duration(hours * float64(time.Hour)))
+	}
+	h0 := moonAltitude(start, lat, lng) - hc
+
+	var riseH, setH float64
+	var found struct{ rise, set bool }
+	var ye float64
+	for i := 1.0; i <= 24; i += 2 {
+		h1 := moonAltitude(at(i), lat, lng) - hc
+		h2 := moonAltitude(at(i+1), lat, lng) - hc
+
+		a := (h0+h2)/2 - h1
+		b := (h2 - h0) / 2
+		xe := -b / (2 * a)
+		ye = (a*xe+b)*xe + h1
+		disc := b*b - 4*a*h1
+		roots := 0
+		var x1, x2 float64
+		if disc >= 0 {
+			dx := math.Sqrt(disc) / (math.Abs(a) * 2)
+			x1 = xe - dx
+			x2 = xe + dx
+			if math.Abs(x1) <= 1 {
+				roots++
+			}
+			if math.Abs(x2) <= 1 {
+				roots++
+			}
+			if x1 < -1 {
+				x1 = x2
+			}
+		}
+
+		switch roots {
+		case 1:
+			if h0 < 0 {
+				riseH, found.rise = i+x1, true
+			} else {
+				setH, found.set = i+x1, true
+			}
+		case 2:
+			if ye < 0 {
+				riseH, setH = i+x2, i+x1
+			} else {
+				riseH, setH = i+x1, i+x2
+			}
+			found.rise, found.set = true, true
+		}
+		if found.rise && found.set {
+			break
+		}
+		h0 = h2
+	}
+
+	if found.rise {
+		t := at(riseH)
+		rise = &t
+	}
+	if found.set {
+		t := at(setH)
+		set = &t
+	}
+	if !found.rise && !found.set {
+		alwaysUp = ye > 0
+		alwaysDown = !alwaysUp
+	}
+	return rise, set, alwaysUp, alwaysDown
+}
+
+// moonPhaseNames split the lunar cycle into eight named phases
+var moonPhaseNames = []struct {
+	slug string
+	name localized
+}{
+	{"new_moon", localized{"新月", "New moon"}},
+	{"waxing_crescent", localized{"蛾眉月", "Waxing crescent"}},
+	{"first_quarter", localized{"上弦月", "First quarter"}},
+	{"waxing_gibbous", localized{"盈凸月", "Waxing gibbous"}},
+	{"full_moon", localized{"满月", "Full moon"}},
+	{"waning_gibbous", localized{"亏凸月", "Waning gibbous"}},
+	{"last_quarter", localized{"下弦月", "Last quarter"}},
+	{"waning_crescent", localized{"残月", "Waning crescent"}},
+}
+
+// TimeRange is a period such as the morning golden hour
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// SunInfo holds the sun events of one day. Events that do not happen, e.g.
+// during polar day or night, are nil.
+type SunInfo struct {
+	Sunrise          *time.Time  `json:"sunrise"`
+	Sunset           *time.Time  `json:"sunset"`
+	SolarNoon        time.Time   `json:"solar_noon"`
+	DayLength        float64     `json:"day_length"` // hours
+	CivilDawn        *time.Time  `json:"civil_dawn"`
+	CivilDusk        *time.Time  `json:"civil_dusk"`
+	NauticalDawn     *time.Time  `json:"nautical_dawn"`
+	NauticalDusk     *time.Time  `json:"nautical_dusk"`
+	AstronomicalDawn *time.Time  `json:"astronomical_dawn"`
+	AstronomicalDusk *time.Time  `json:"astronomical_dusk"`
+	GoldenHour       []TimeRange `json:"golden_hour"`
+	BlueHour         []TimeRange `json:"blue_hour"`
+	PolarDay         bool        `json:"polar_day"`
+	PolarNight       bool        `json:"polar_night"`
+}
+
+// MoonInfo holds the moon events and phase of one day
+type MoonInfo struct {
+	Moonrise     *time.Time `json:"moonrise"`
+	Moonset      *time.Time `json:"moonset"`
+	AlwaysUp     bool       `json:"always_up"`
+	AlwaysDown   bool       `json:"always_down"`
+	Phase        float64    `json:"phase"` // 0 new, 0.5 full
+	PhaseName    string     `json:"phase_name"`
+	PhaseSlug    string     `json:"phase_slug"`
+	Illumination float64    `json:"illumination"` // 0..1
+}
+
+// AstroDay is the sun and moon data for one date at one place
+type AstroDay struct {
+	Date      string   `json:"date"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Timezone  string   `json:"timezone"`
+	Sun       SunInfo  `json:"sun"`
+	Moon      MoonInfo `json:"moon"`
+}
+
+// computeAstro calculates sun and moon data for the calendar date of day in
+// zone. All times are returned in zone.
+func computeAstro(lat, lng float64, day time.Time, zone *time.Location, lang Lang) AstroDay {
+	y, m, d := day.In(zone).Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, zone)
+	// Anchor on the local solar noon so that the events belong to this date
+	solar := newSolarDay(time.Date(y, m, d, 12, 0, 0, 0, time.UTC).Add(time.Duration(-lng/15*float64(time.Hour))), lat, lng)
+
+	local := func(t time.Time) *time.Time {
+		t = t.In(zone).Round(time.Second)
+		return &t
+	}
+	event := func(h float64) (rise, set *time.Time) {
+		r, s, ok := solar.times(h)
+		if !ok {
+			return nil, nil
+		}
+		return local(r), local(s)
+	}
+
+	sun := SunInfo{
+		SolarNoon:  *local(fromJulian(solar.noon)),
+		GoldenHour: []TimeRange{},
+		BlueHour:   []TimeRange{},
+	}
+	sun.Sunrise, sun.Sunset = event(altitudeSunrise)
+	sun.CivilDawn, sun.CivilDusk = event(altitudeCivil)
+	sun.NauticalDawn, sun.NauticalDusk = event(altitudeNautical)
+	sun.AstronomicalDawn, sun.AstronomicalDusk = event(altitudeAstronomical)
+
+	noonAltitude := 90 - math.Abs(lat-solar.dec/rad)
+	switch {
+	case sun.Sunrise != nil:
+		sun.DayLength = roundTo(sun.Sunset.Sub(*sun.Sunrise).Hours(), 2)
+	case noonAltitude > altitudeSunrise:
+		sun.PolarDay = true
+		sun.DayLength = 24
+	default:
+		sun.PolarNight = true
+	}
+
+	// Golden hour runs from -4° to +6°, blue hour from -6° to -4°
+	blueEndRise, blueEndSet := event(altitudeBlueHourEnd)
+	goldenRise, goldenSet := event(altitudeGoldenHour)
+	if blueEndRise != nil {
+		if goldenRise != nil {
+			sun.GoldenHour = append(sun.GoldenHour, TimeRange{*blueEndRise, *goldenRise}, TimeRange{*goldenSet, *blueEndSet})
+		}
+		if sun.CivilDawn != nil {
+			sun.BlueHour = append(sun.BlueHour, TimeRange{*sun.CivilDawn, *blueEndRise}, TimeRange{*blueEndSet, *sun.CivilDusk})
+		}
+	}
+
+	fraction, phase := moonIllumination(midnight.Add(12 * time.Hour))
+	name := moonPhaseNames[int(math.Round(phase*8))%8]
+	moon := MoonInfo{
+		Phase:        roundTo(phase, 3),
+		PhaseName:    name.name.in(lang),
+		PhaseSlug:    name.slug,
+		Illumination: roundTo(fraction, 3),
+	}
+	var rise, set *time.Time
+	rise, set, moon.AlwaysUp, moon.AlwaysDown = moonTimes(midnight, lat, lng)
+	if rise != nil {
+		moon.Moonrise = local(*rise)
+	}
+	if set != nil {
+		moon.Moonset = local(*set)
+	}
+
+	return AstroDay{
+		Date:      midnight.Format("2006-01-02"),
+		Latitude:  lat,
+		Longitude: lng,
+		Timezone:  zone.String(),
+		Sun:       sun,
+		Moon:      moon,
+	}
+}
+
+// sunSchedule resolves sunrise and sunset per local date for a forecast,
+// preferring Caiyun's astro data and computing missing days locally
+type sunSchedule struct {
+	zone      *time.Location
+	lat, lng  float64
+	hasCoords bool
+	days      map[string]sunDay
+}
+
+// sunDay is the daylight period of one date. Both times are nil during
+// polar day or night.
+type sunDay struct {
+	sunrise, sunset *time.Time
+	polarDay        bool
+}
+
+func newSunSchedule(full *CaiyunAPIResponse, times *caiyunTimes) *sunSchedule {
+	s := &sunSchedule{
+		zone: times.zone,
+		days: make(map[string]sunDay),
+	}
+	if len(full.Location) == 2 {
+		s.lat, s.lng, s.hasCoords = full.Location[0], full.Location[1], true
+	}
+
+	for i, astro := range full.Result.Daily.Astro {
+		field := fmt.Sprintf("daily.astro[%d]", i)
+		date, ok := times.parse(field+".date", astro.Date)
+		if !ok {
+			continue
+		}
+		sunrise, ok1 := times.clock(field+".sunrise", date, astro.Sunrise.Time)
+		sunset, ok2 := times.clock(field+".sunset", date, astro.Sunset.Time)
+		if !ok1 || !ok2 {
+			continue
+		}
+		s.days[date.Format("2006-01-02")] = sunDay{sunrise: &sunrise, sunset: &sunset}
+	}
+	return s
+}
+
+// day returns the daylight period of the local date of t. ok is false when
+// neither provider data nor coordinates are available.
+func (s *sunSchedule) day(t time.Time) (sunDay, bool) {
+	key := t.In(s.zone).Format("2006-01-02")
+	if day, ok := s.days[key]; ok {
+		return day, true
+	}
+	if !s.hasCoords {
+		return sunDay{}, false
+	}
+	astro := computeAstro(s.lat, s.lng, t, s.zone, LangZH)
+	day := sunDay{sunrise: astro.Sun.Sunrise, sunset: astro.Sun.Sunset, polarDay: astro.Sun.PolarDay}
+	s.days[key] = day
+	return day, true
+}
+
+// isDaytime reports whether the sun is up at t. Without any data, 06:00 to
+// 18:00 local time counts as day.
+func (s *sunSchedule) isDaytime(t time.Time) bool {
+	day, ok := s.day(t)
+	if !ok {
+		hour := t.In(s.zone).Hour()
+		return hour >= 6 && hour < 18
+	}
+	if day.sunrise == nil || day.sunset == nil {
+		return day.polarDay
+	}
+	return !t.Before(*day.sunrise) && t.Before(*day.sunset)
+}
+
+// sunPosition returns the sun's azimuth (degrees clockwise from north) and
+// altitude (degrees above the horizon) at t
+func sunPosition(t time.Time, lat, lng float64) (azimuth, elevation float64) {
+	lw := rad * -lng
+	phi := rad * lat
+	d := toDays(t)
+	dec, ra := sunCoords(d)
+	h := siderealTime(d, lw) - ra
+
+	// Measured from south towards west, then turned into a compass bearing
+	az := math.Atan2(math.Sin(h), math.Cos(h)*math.Sin(phi)-math.Tan(dec)*math.Cos(phi))
+	azimuth = math.Mod(az/rad+180, 360)
+	return azimuth, altitude(h, phi, dec) / rad
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// briefingRainProbability is the hourly probability (%) from which rain
+	// is mentioned in a briefing
+	briefingRainProbability = 30
+	// briefingWindBeaufort is the Beaufort force from which the day's
+	// strongest wind is mentioned
+	briefingWindBeaufort = 5
+)
+
+// defaultBriefingTemplates are used for languages without a template file
+var defaultBriefingTemplates = map[Lang]string{
+	LangZH: `{{.Condition}}，{{temp .TemperatureMin}}~{{temp .TemperatureMax}}°C` +
+		`{{with .Rain}}，{{if not .From.IsZero}}{{clock .From}}后{{end}}降水概率{{.Probability}}%{{end}}` +
+		`{{if .Umbrella}}，记得带伞{{end}}` +
+		`{{with .Wind}}，{{.}}{{end}}` +
+		`{{with .AirQuality}}；空气质量{{.}}{{end}}` +
+		`{{range .Alerts}}。{{.}}{{end}}。`,
+	LangEN: `{{.Condition}}, {{temp .TemperatureMin}}–{{temp .TemperatureMax}}°C` +
+		`{{with .Rain}}, {{.Probability}}% chance of rain{{if not .From.IsZero}} after {{clock .From}}{{end}}{{end}}` +
+		`{{if .Umbrella}}, take an umbrella{{end}}` +
+		`{{with .Wind}}, {{.}}{{end}}` +
+		`{{with .AirQuality}}; AQI {{.}}{{end}}` +
+		`{{range .Alerts}}. {{.}}{{end}}.`,
+}
+
+// briefingClock formats an hour the way each language says it, e.g. "3pm"
+// or "15点"
+var briefingClock = map[Lang]func(time.Time) string{
+	LangZH: func(t time.Time) string {
+		if t.Minute() == 0 {
+			return fmt.Sprintf("%d点", t.Hour())
+		}
+		return t.Format("15:04")
+	},
+	LangEN: func(t time.Time) string {
+		if t.Minute() == 0 {
+			return strings.ToLower(t.Format("3PM"))
+		}
+		return strings.ToLower(t.Format("3:04PM"))
+	},
+}
+
+// BriefingRain describes the rain expected during the briefing day
+type BriefingRain struct {
+	Probability int       `json:"probability"`
+	From        time.Time `json:"from,omitzero"` // first hour above the threshold, zero when only the daily forecast is known
+}
+
+// BriefingData is the input of a briefing template
+type BriefingData struct {
+	Date           time.Time     `json:"date"`
+	Condition      string        `json:"condition"`
+	TemperatureMin float64       `json:"temperature_min"`
+	TemperatureMax float64       `json:"temperature_max"`
+	Rain           *BriefingRain `json:"rain,omitempty"`
+	Umbrella       bool          `json:"umbrella"`
+	Wind           string        `json:"wind,omitempty"`
+	AQI            int           `json:"aqi"`
+	AirQuality     string        `json:"air_quality"`
+	Alerts         []string      `json:"alerts"`
+}
+
+// Briefing is a rendered natural-language forecast for one day
+type Briefing struct {
+	Location string       `json:"location"`
+	Lang     Lang         `json:"lang"`
+	Date     string       `json:"date"`
+	Text     string       `json:"text"`
+	Data     BriefingData `json:"data"`
+}
+
+// BriefingTemplates renders briefings with one template per language. The
+// umbrella suggestion follows the advice rules, so that briefings and advice
+// agree.
+type BriefingTemplates struct {
+	templates map[Lang]*template.Template
+	umbrella  UmbrellaRule
+}
+
+// newBriefingTemplates parses the built-in templates and overrides them with
+// <lang>.tmpl files from dir, if dir is set
+func newBriefingTemplates(dir string, umbrella UmbrellaRule) (*BriefingTemplates, error) {
+	b := &BriefingTemplates{templates: make(map[Lang]*template.Template), umbrella: umbrella}
+	for lang, text := range defaultBriefingTemplates {
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, string(lang)+".tmpl"))
+			if err == nil {
+				text = strings.TrimSpace(string(data))
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		tmpl, err := template.New(string(lang)).Funcs(template.FuncMap{
+			"temp":  func(v float64) string { return fmt.Sprintf("%.0f", math.Round(v)) },
+			"clock": briefingClock[lang],
+		}).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("briefing template %s: %w", lang, err)
+		}
+		b.templates[lang] = tmpl
+	}
+	return b, nil
+}
+
+// Generate renders the briefing for the day-th forecast day, 0 being today
+func (b *BriefingTemplates) Generate(location string, light *LightWeatherResponse, zone *time.Location, day int, lang Lang) (*Briefing, error) {
+	if day < 0 || day >= len(light.Daily) {
+		return nil, fmt.Errorf("no forecast for day %d, %d days available", day, len(light.Daily))
+	}
+	data := briefingData(light, zone, day, b.umbrella, lang)
+
+	var buf bytes.Buffer
+	if err := b.templates[lang].Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return &Briefing{
+		Location: location,
+		Lang:     lang,
+		Date:     data.Date.Format("2006-01-02"),
+		Text:     buf.String(),
+		Data:     data,
+	}, nil
+}
+
+// briefingData summarizes one forecast day. Rain timing comes from the
+// hourly series when it covers the day, otherwise from the daily forecast.
+// An umbrella is suggested when the day's highest probability or peak
+// intensity meets the umbrella rule.
+func briefingData(light *LightWeatherResponse, zone *time.Location, day int, umbrella UmbrellaRule, lang Lang) BriefingData {
+	daily := light.Daily[day]
+	date := daily.Date.In(zone)
+	data := BriefingData{
+		Date:           date,
+		Condition:      skyconText(daily.Condition, lang),
+		TemperatureMin: daily.TemperatureMin,
+		TemperatureMax: daily.TemperatureMax,
+		AQI:            daily.AirQuality.AQI,
+		Alerts:         []string{},
+	}
+	if daily.Wind.Beaufort >= briefingWindBeaufort {
+		data.Wind = windText(daily.Wind, lang)
+	}
+	if daily.AirQuality.AQI > 0 {
+		data.AirQuality = aqiLevelText(daily.AirQuality.AQI, lang)
+	}
+
+	probability := daily.PrecipitationProb
+	var from time.Time
+	now := time.Now()
+	for _, hour := range light.Hourly {
+		if hour.Time.IsZero() || hour.Time.Before(now.Truncate(time.Hour)) {
+			continue
+		}
+		local := hour.Time.In(zone)
+		if local.Year() != date.Year() || local.YearDay() != date.YearDay() {
+			continue
+		}
+		if hour.PrecipitationProb >= briefingRainProbability && from.IsZero() {
+			from = local
+		}
+		if hour.PrecipitationProb > probability {
+			probability = hour.PrecipitationProb
+		}
+	}
+	if probability >= briefingRainProbability {
+		data.Rain = &BriefingRain{Probability: probability, From: from}
+	}
+	data.Umbrella = umbrella.needed(probability, daily.PrecipitationMM)
+
+	if day == 0 {
+		for _, alert := range light.Alerts {
+			if alertCancelled(alert) {
+				continue
+			}
+			class := AlertClass{Type: alert.Type, Color: alert.Color, Severity: alert.Severity}
+			data.Alerts = append(data.Alerts, localizedAlertTitle(alert.Title, "", class, lang))
+		}
+	}
+	return data
+}
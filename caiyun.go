@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultZone is used for locations whose timezone is not known yet
+var defaultZone = time.FixedZone("Asia/Shanghai", 8*3600)
+
+const (
+	// forecastHours and forecastDays are the horizons requested from Caiyun.
+	// Verification lead times, the calendar, briefings, solar estimates,
+	// event windows and webhook thresholds all reach this far ahead.
+	forecastHours = 72
+	forecastDays  = 7
+)
+
+// fetchCaiyunWeather requests the full weather report for geopos from the
+// Caiyun API
+func fetchCaiyunWeather(token, geopos string) (*CaiyunAPIResponse, error) {
+	if token == "" {
+		return nil, errors.New("CAIYUN_WEATHER_TOKEN not set")
+	}
+
+	caiyunURL := fmt.Sprintf("https://api.caiyunapp.com/v2.6/%s/%s/weather?alert=true&dailysteps=%d&hourlysteps=%d", token, geopos, forecastDays, forecastHours)
+
+	log.Printf("Requesting weather data from Caiyun API: %s", strings.Replace(caiyunURL, token, "***", 1))
+
+	resp, err := http.Get(caiyunURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch weather data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read response body: %w", err)
+	}
+
+	var caiyunResp CaiyunAPIResponse
+	if err := json.Unmarshal(body, &caiyunResp); err != nil {
+		return nil, fmt.Errorf("Failed to parse weather data: %w", err)
+	}
+
+	if caiyunResp.Status != "ok" {
+		return nil, fmt.Errorf("Caiyun API returned status: %s; msg=%s", caiyunResp.Status, caiyunResp.ErrorMsg)
+	}
+
+	return &caiyunResp, nil
+}
+
+// normalizeGeopos validates a "longitude,latitude" pair and rewrites it with
+// fixed precision so it can be used as a stable location key
+func normalizeGeopos(geopos string) (string, error) {
+	lng, lat, err := parseGeopos(geopos)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%.4f,%.4f", lng, lat), nil
+}
+
+// parseGeopos splits a "longitude,latitude" pair into validated coordinates
+func parseGeopos(geopos string) (lng, lat float64, err error) {
+	lngStr, latStr, ok := strings.Cut(geopos, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("geopos must be \"longitude,latitude\", got %q", geopos)
+	}
+	lng, err = strconv.ParseFloat(strings.TrimSpace(lngStr), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude in geopos %q", geopos)
+	}
+	lat, err = strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude in geopos %q", geopos)
+	}
+	return lng, lat, nil
+}
+
+// locationZone returns the fixed-offset timezone reported for a location
+func locationZone(resp *CaiyunAPIResponse) *time.Location {
+	if resp.Timezone == "" && resp.TZShift == 0 {
+		return defaultZone
+	}
+	return time.FixedZone(resp.Timezone, resp.TZShift)
+}
+
+// caiyunTimeLayouts are the timestamp formats used in Caiyun responses:
+// hourly and daily series omit seconds, other fields use RFC3339
+var caiyunTimeLayouts = []string{
+	"2006-01-02T15:04-07:00",
+	time.RFC3339,
+}
+
+// parseCaiyunTime parses a timestamp in any of the Caiyun formats
+func parseCaiyunTime(s string) (time.Time, error) {
+	for _, layout := range caiyunTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
+
+// caiyunTimes parses the timestamps of one response into the location's
+// timezone. Values that cannot be parsed are recorded as warnings instead of
+// silently becoming zero times.
+type caiyunTimes struct {
+	zone     *time.Location
+	warnings []string
+}
+
+func newCaiyunTimes(resp *CaiyunAPIResponse) *caiyunTimes {
+	return &caiyunTimes{zone: locationZone(resp)}
+}
+
+// parse parses a timestamp of field, e.g. "hourly.temperature[3].datetime"
+func (ct *caiyunTimes) parse(field, raw string) (time.Time, bool) {
+	t, err := parseCaiyunTime(raw)
+	if err != nil {
+		ct.warn(field, err)
+		return time.Time{}, false
+	}
+	return t.In(ct.zone), true
+}
+
+// clock combines a date with an "HH:MM" time of day such as a sunrise
+func (ct *caiyunTimes) clock(field string, date time.Time, raw string) (time.Time, bool) {
+	t, err := time.ParseInLocation("2006-01-02 15:04", date.In(ct.zone).Format("2006-01-02 ")+raw, ct.zone)
+	if err != nil {
+		ct.warn(field, fmt.Errorf("unrecognized time of day %q", raw))
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// unix converts a Unix timestamp, treating 0 as missing
+func (ct *caiyunTimes) unix(field string, sec int64) (time.Time, bool) {
+	if sec <= 0 {
+		ct.warn(field, fmt.Errorf("missing timestamp"))
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0).In(ct.zone), true
+}
+
+func (ct *caiyunTimes) warn(field string, err error) {
+	ct.warnings = append(ct.warnings, fmt.Sprintf("%s: %v", field, err))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	calendarProdID = "-//LakeLink//UniUtilities Weather//ZH"
+	// alertEventDuration is how long an alert event lasts in the calendar.
+	// Caiyun does not report when an alert expires.
+	alertEventDuration = 24 * time.Hour
+	icsLineLimit       = 75
+)
+
+// calendarWriter builds an iCalendar (RFC 5545) document
+type calendarWriter struct {
+	b strings.Builder
+}
+
+// line writes a content line, folding it at 75 octets without splitting a
+// UTF-8 sequence. The space that starts a continuation line counts towards
+// its 75 octets.
+func (w *calendarWriter) line(name, value string) {
+	s := name + ":" + value
+	limit := icsLineLimit
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		w.b.WriteString(s[:cut])
+		w.b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = icsLineLimit - 1
+	}
+	w.b.WriteString(s)
+	w.b.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// escapeICSText escapes a TEXT property value
+func escapeICSText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// buildWeatherCalendar renders one all-day event per forecast day and one
+// timed event per active alert. UIDs depend only on the location and the day
+// or alert ID, so subscribed clients update existing events on refresh.
+func buildWeatherCalendar(location string, light *LightWeatherResponse, zone *time.Location) string {
+	w := &calendarWriter{}
+	stamp := light.LastUpdated.UTC().Format("20060102T150405Z")
+	uidSuffix := strings.ReplaceAll(location, ",", "_") + "@lakelink"
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", calendarProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.line("X-WR-CALNAME", escapeICSText("天气预报 "+location))
+	w.line("X-WR-TIMEZONE", zone.String())
+	w.line("REFRESH-INTERVAL;VALUE=DURATION", "PT1H")
+	w.line("X-PUBLISHED-TTL", "PT1H")
+
+	for _, day := range light.Daily {
+		date := day.Date.In(zone)
+		start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, zone)
+		end := start.AddDate(0, 0, 1)
+		condition := translateSkycon(day.Condition)
+
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", "daily-"+start.Format("20060102")+"-"+uidSuffix)
+		w.line("DTSTAMP", stamp)
+		w.line("LAST-MODIFIED", stamp)
+		w.line("DTSTART;VALUE=DATE", start.Format("20060102"))
+		w.line("DTEND;VALUE=DATE", end.Format("20060102"))
+		w.line("SUMMARY", escapeICSText(fmt.Sprintf("%s %.0f~%.0f°C", condition, day.TemperatureMin, day.TemperatureMax)))
+		w.line("DESCRIPTION", escapeICSText(fmt.Sprintf(
+			"天气：%s\n气温：%.1f~%.1f°C\n降水概率：%d%%\n降水量：%.1f mm",
+			condition, day.TemperatureMin, day.TemperatureMax, day.PrecipitationProb, day.PrecipitationMM,
+		)))
+		w.line("TRANSP", "TRANSPARENT")
+		w.line("END", "VEVENT")
+	}
+
+	for _, alert := range light.Alerts {
+		if alert.ID == "" || alertCancelled(alert) {
+			continue
+		}
+		// Undated alerts start when the report was issued
+		start := alert.PublishedAt
+		if start.IsZero() {
+			start = light.LastUpdated
+		}
+		start = start.UTC()
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", "alert-"+alert.ID+"@lakelink")
+		w.line("DTSTAMP", stamp)
+		w.line("DTSTART", start.Format("20060102T150405Z"))
+		w.line("DTEND", start.Add(alertEventDuration).Format("20060102T150405Z"))
+		w.line("SUMMARY", escapeICSText(alert.Title))
+		w.line("DESCRIPTION", escapeICSText(alert.Description))
+		w.line("CATEGORIES", escapeICSText(alert.TypeName))
+		w.line("PRIORITY", fmt.Sprint(alertPriority(alert.Severity)))
+		if alert.Location != "" {
+			w.line("LOCATION", escapeICSText(alert.Location))
+		}
+		w.line("TRANSP", "TRANSPARENT")
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+	return w.b.String()
+}
+
+// alertPriority maps severity to the iCalendar PRIORITY scale, where 1 is
+// the highest and 0 means undefined
+func alertPriority(severity AlertSeverity) int {
+	switch severity {
+	case AlertSeverityExtreme:
+		return 1
+	case AlertSeveritySevere:
+		return 3
+	case AlertSeverityModerate:
+		return 5
+	case AlertSeverityMinor:
+		return 7
+	}
+	return 0
+}
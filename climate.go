@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// normalWindowDays is how many days either side of a day of year
+	// contribute to its normal
+	normalWindowDays = 7
+
+	// minNormalDays is the number of daily records a day-of-year window
+	// needs before its normal is considered meaningful
+	minNormalDays = 10
+
+	// minNormalYears is the number of distinct years a window must span, so
+	// that a few weeks of recent history are not mistaken for a normal
+	minNormalYears = 3
+
+	// normalsTTL is how long computed normals are reused
+	normalsTTL = time.Hour
+)
+
+// DailyRecord is the observed summary of one day, either imported or derived
+// from recorded history
+type DailyRecord struct {
+	Date           string   `json:"date"` // YYYY-MM-DD, local time
+	TemperatureMin *float64 `json:"temperature_min,omitempty"`
+	TemperatureMax *float64 `json:"temperature_max,omitempty"`
+	Precipitation  *float64 `json:"precipitation,omitempty"` // mm
+}
+
+// DailyNormal is the climatological normal for one day of the year
+type DailyNormal struct {
+	TemperatureMin    float64 `json:"temperature_min"`
+	TemperatureMax    float64 `json:"temperature_max"`
+	Precipitation     float64 `json:"precipitation"`      // mean mm per day
+	PrecipitationDays float64 `json:"precipitation_days"` // share of days with rain
+	Samples           int     `json:"samples"`            // days
+	Years             int     `json:"years"`
+}
+
+type normalSum struct {
+	min, max, precip sumCount
+	rainDays         int
+	years            map[int]bool
+}
+
+type sumCount struct {
+	sum float64
+	n   int
+}
+
+func (s *sumCount) add(v float64) {
+	s.sum += v
+	s.n++
+}
+
+type climateNormals struct {
+	computedAt time.Time
+	imported   bool // daily normals include imported climatology
+	daily      [365]*DailyNormal
+	hourly     [365][24]map[int]*sumCount // recorded temperatures by year
+}
+
+// ClimateStore combines imported daily records with recorded history to
+// compute day-of-year normals per location
+type ClimateStore struct {
+	dir     string
+	history *HistoryStore
+
+	mu       sync.Mutex
+	imported map[string]map[string]DailyRecord
+	normals  map[string]*climateNormals
+}
+
+// newClimateStore opens the imported records in dir
+func newClimateStore(dir string, history *HistoryStore) (*ClimateStore, error) {
+	cs := &ClimateStore{
+		dir:      dir,
+		history:  history,
+		imported: make(map[string]map[string]DailyRecord),
+		normals:  make(map[string]*climateNormals),
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		records, err := readNDJSONFile[DailyRecord](file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		byDate := make(map[string]DailyRecord)
+		for _, record := range records {
+			byDate[record.Date] = record
+		}
+		cs.imported[strings.TrimSuffix(filepath.Base(file), ".ndjson")] = byDate
+	}
+	return cs, nil
+}
+
+// Import stores daily records for location, replacing earlier records for
+// the same dates
+func (cs *ClimateStore) Import(location string, records []DailyRecord) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	byDate := cs.imported[location]
+	if byDate == nil {
+		byDate = make(map[string]DailyRecord)
+		cs.imported[location] = byDate
+	}
+	path := filepath.Join(cs.dir, location+".ndjson")
+	for _, record := range records {
+		if err := appendNDJSONFile(path, record); err != nil {
+			return err
+		}
+		byDate[record.Date] = record
+	}
+	delete(cs.normals, location)
+	return nil
+}
+
+// DailyNormal returns the normal for the day of year of date
+func (cs *ClimateStore) DailyNormal(location string, date time.Time) *DailyNormal {
+	return cs.computed(location).daily[dayOfYear(date)]
+}
+
+// TemperatureNormal estimates the normal temperature at t. Without imported
+// climatology, recorded hourly history is used once it spans minNormalYears
+// years, each year weighing the same however often it was sampled.
+// Otherwise the daily normal range is interpolated over a typical diurnal
+// cycle.
+func (cs *ClimateStore) TemperatureNormal(location string, t time.Time) (float64, bool) {
+	normals := cs.computed(location)
+	doy, hour := dayOfYear(t), t.Hour()
+
+	if !normals.imported {
+		years := make(map[int]*sumCount)
+		for offset := -normalWindowDays; offset <= normalWindowDays; offset++ {
+			for year, bucket := range normals.hourly[(doy+offset+365)%365][hour] {
+				total := years[year]
+				if total == nil {
+					total = &sumCount{}
+					years[year] = total
+				}
+				total.sum += bucket.sum
+				total.n += bucket.n
+			}
+		}
+		if len(years) >= minNormalYears {
+			var mean sumCount
+			for _, total := range years {
+				mean.add(total.sum / float64(total.n))
+			}
+			return mean.sum / float64(mean.n), true
+		}
+	}
+
+	daily := normals.daily[doy]
+	if daily == nil {
+		return 0, false
+	}
+	// Coldest around 05:00 and warmest around 15:00: half a cosine rising
+	// over the 10 warming hours, another falling over the 14 cooling hours
+	h := float64(hour) + float64(t.Minute())/60
+	var phase float64
+	if h >= 5 && h < 15 {
+		phase = -math.Cos(math.Pi * (h - 5) / 10)
+	} else {
+		phase = math.Cos(math.Pi * math.Mod(h-15+24, 24) / 14)
+	}
+	mid := (daily.TemperatureMax + daily.TemperatureMin) / 2
+	amp := (daily.TemperatureMax - daily.TemperatureMin) / 2
+	return mid + amp*phase, true
+}
+
+// computed returns the normals for location, recomputing them when stale
+func (cs *ClimateStore) computed(location string) *climateNormals {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if normals, ok := cs.normals[location]; ok && time.Since(normals.computedAt) < normalsTTL {
+		return normals
+	}
+
+	observations := cs.history.Query(location, time.Time{}, time.Now().Add(time.Hour))
+
+	records := dailyRecordsFromHistory(observations)
+	for date, record := range cs.imported[location] {
+		records[date] = record
+	}
+
+	normals := &climateNormals{computedAt: time.Now(), imported: len(cs.imported[location]) > 0}
+	var sums [365]normalSum
+	for date, record := range records {
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		sum := &sums[dayOfYear(day)]
+		if sum.years == nil {
+			sum.years = make(map[int]bool)
+		}
+		sum.years[day.Year()] = true
+		if record.TemperatureMin != nil {
+			sum.min.add(*record.TemperatureMin)
+		}
+		if record.TemperatureMax != nil {
+			sum.max.add(*record.TemperatureMax)
+		}
+		if record.Precipitation != nil {
+			sum.precip.add(*record.Precipitation)
+			if *record.Precipitation >= rainThreshold {
+				sum.rainDays++
+			}
+		}
+	}
+
+	for doy := range sums {
+		window := normalSum{years: make(map[int]bool)}
+		for offset := -normalWindowDays; offset <= normalWindowDays; offset++ {
+			s := sums[(doy+offset+365)%365]
+			for year := range s.years {
+				window.years[year] = true
+			}
+			window.min.sum += s.min.sum
+			window.min.n += s.min.n
+			window.max.sum += s.max.sum
+			window.max.n += s.max.n
+			window.precip.sum += s.precip.sum
+			window.precip.n += s.precip.n
+			window.rainDays += s.rainDays
+		}
+		if window.min.n < minNormalDays || window.max.n < minNormalDays || len(window.years) < minNormalYears {
+			continue
+		}
+		normal := &DailyNormal{
+			TemperatureMin: roundTo(window.min.sum/float64(window.min.n), 1),
+			TemperatureMax: roundTo(window.max.sum/float64(window.max.n), 1),
+			Samples:        min(window.min.n, window.max.n),
+			Years:          len(window.years),
+		}
+		if window.precip.n > 0 {
+			normal.Precipitation = roundTo(window.precip.sum/float64(window.precip.n), 1)
+			normal.PrecipitationDays = roundTo(float64(window.rainDays)/float64(window.precip.n), 2)
+		}
+		normals.daily[doy] = normal
+	}
+
+	for _, obs := range observations {
+		bucket := &normals.hourly[dayOfYear(obs.Time)][obs.Time.Hour()]
+		if *bucket == nil {
+			*bucket = make(map[int]*sumCount)
+		}
+		year := (*bucket)[obs.Time.Year()]
+		if year == nil {
+			year = &sumCount{}
+			(*bucket)[obs.Time.Year()] = year
+		}
+		year.add(obs.Temperature)
+	}
+
+	cs.normals[location] = normals
+	return normals
+}
+
+// dailyRecordsFromHistory summarizes each sufficiently covered local day
+func dailyRecordsFromHistory(observations []Observation) map[string]DailyRecord {
+	records := make(map[string]DailyRecord)
+	amounts := precipitationAmounts(observations)
+
+	for start := 0; start < len(observations); {
+		date := observations[start].Time.Format("2006-01-02")
+		end := start
+		for end < len(observations) && observations[end].Time.Format("2006-01-02") == date {
+			end++
+		}
+
+		if observations[end-1].Time.Sub(observations[start].Time) >= minDailyCoverage {
+			minT, maxT, rain := math.Inf(1), math.Inf(-1), 0.0
+			for i := start; i < end; i++ {
+				minT = math.Min(minT, observations[i].Temperature)
+				maxT = math.Max(maxT, observations[i].Temperature)
+				rain += amounts[i]
+			}
+			records[date] = DailyRecord{Date: date, TemperatureMin: &minT, TemperatureMax: &maxT, Precipitation: &rain}
+		}
+		start = end
+	}
+	return records
+}
+
+// dayOfYear maps a date to 0..364 on a non-leap calendar; 29 February
+// shares 28 February's normal
+func dayOfYear(t time.Time) int {
+	day := t.Day()
+	if t.Month() == time.February && day == 29 {
+		day = 28
+	}
+	return time.Date(2001, t.Month(), day, 0, 0, 0, 0, time.UTC).YearDay() - 1
+}
+
+// applyAnomalies fills in normals and departures from normal. A nil store
+// leaves light unchanged.
+func (cs *ClimateStore) applyAnomalies(location string, light *LightWeatherResponse) {
+	if cs == nil || light == nil {
+		return
+	}
+
+	if normal, ok := cs.TemperatureNormal(location, light.LastUpdated.In(cs.history.Zone(location))); ok {
+		normal = roundTo(normal, 1)
+		anomaly := roundTo(light.Current.Temperature-normal, 1)
+		light.Current.TemperatureNormal = &normal
+		light.Current.TemperatureAnomaly = &anomaly
+	}
+
+	for i := range light.Daily {
+		day := &light.Daily[i]
+		normal := cs.DailyNormal(location, day.Date)
+		if normal == nil {
+			continue
+		}
+		minAnomaly := roundTo(day.TemperatureMin-normal.TemperatureMin, 1)
+		maxAnomaly := roundTo(day.TemperatureMax-normal.TemperatureMax, 1)
+		day.Normal = normal
+		day.TemperatureMinAnomaly = &minAnomaly
+		day.TemperatureMaxAnomaly = &maxAnomaly
+	}
+}
+
+// readClimateCSV parses daily records from CSV with a header row. The date
+// column is required; temperature_min/tmin, temperature_max/tmax and
+// precipitation/prcp (mm) are optional. Empty, "NA" and "NaN" cells are
+// treated as missing.
+func readClimateCSV(r io.Reader) ([]DailyRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	columns := map[string]int{}
+	aliases := map[string]string{
+		"date": "date", "day": "date",
+		"temperature_min": "min", "tmin": "min", "min_temperature": "min",
+		"temperature_max": "max", "tmax": "max", "max_temperature": "max",
+		"precipitation": "precip", "prcp": "precip", "precip": "precip",
+	}
+	for i, name := range header {
+		if key, ok := aliases[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))]; ok {
+			columns[key] = i
+		}
+	}
+	if _, ok := columns["date"]; !ok {
+		return nil, fmt.Errorf("missing date column in header %v", header)
+	}
+
+	var records []DailyRecord
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		date, err := parseClimateDate(cell(row, columns, "date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		record := DailyRecord{Date: date}
+		for key, target := range map[string]**float64{
+			"min":    &record.TemperatureMin,
+			"max":    &record.TemperatureMax,
+			"precip": &record.Precipitation,
+		} {
+			raw := cell(row, columns, key)
+			if raw == "" || strings.EqualFold(raw, "NA") || strings.EqualFold(raw, "NaN") {
+				continue
+			}
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s value %q", line, key, raw)
+			}
+			*target = &v
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Date < records[j].Date })
+	return records, nil
+}
+
+func cell(row []string, columns map[string]int, key string) string {
+	i, ok := columns[key]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func parseClimateDate(raw string) (string, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "20060102", "2006/1/2"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", raw)
+}
+
+// runClimateImport implements "import-climate -location <id|geopos> files...".
+// It is meant to bootstrap normals from historical station data and only
+// opens the history and climate stores, so it needs no provider token.
+func runClimateImport(cfg *Config, location string, paths []string) error {
+	if location == "" || len(paths) == 0 {
+		return fmt.Errorf("usage: import-climate -location <id|geopos> file.csv [file.csv ...]")
+	}
+	resolved, err := cfg.resolveLocation(location)
+	if err != nil {
+		return err
+	}
+
+	history, err := newHistoryStore(filepath.Join(cfg.DataDir, "history"), cfg.History.Retention.duration())
+	if err != nil {
+		return fmt.Errorf("open history store: %w", err)
+	}
+	climate, err := newClimateStore(filepath.Join(cfg.DataDir, "climate"), history)
+	if err != nil {
+		return fmt.Errorf("open climate store: %w", err)
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		records, err := readClimateCSV(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := climate.Import(resolved, records); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Printf("Imported %d days from %s for %s\n", len(records), path, resolved)
+	}
+	return nil
+}
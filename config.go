@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the service configuration. It is built from defaults, then the
+// JSON file named by -config or CONFIG_FILE, then environment variables and
+// finally command line flags, each overriding the previous. See
+// config/config.example.json.
+type Config struct {
+	ListenAddr string            `json:"listen_addr"`
+	DataDir    string            `json:"data_dir"`
+	Providers  []ProviderConfig  `json:"providers"` // tried in order until one succeeds
+	Locations  map[string]string `json:"locations"` // location ID to "longitude,latitude"
+	Cache      CacheConfig       `json:"cache"`
+	History    HistoryConfig     `json:"history"`
+	Stream     StreamConfig      `json:"stream"`
+	CORS       CORSConfig        `json:"cors"`
+	RateLimit  RateLimitConfig   `json:"rate_limit"`
+	// AdminToken authorizes listing and managing every webhook
+	// subscription. Listing is disabled when empty.
+	AdminToken string `json:"admin_token"`
+
+	BriefingTemplateDir string `json:"briefing_template_dir"`
+	AdviceRulesFile     string `json:"advice_rules_file"`
+	PolicyFile          string `json:"policy_file"`
+}
+
+// ProviderConfig is a weather data source. Only "caiyun" is supported.
+type ProviderConfig struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// CacheConfig controls how long reports are reused and how often watched
+// locations are refreshed
+type CacheConfig struct {
+	TTL             Duration `json:"ttl"`
+	RefreshInterval Duration `json:"refresh_interval"`
+}
+
+// HistoryConfig controls how long recorded observations are kept. A
+// retention of 0 keeps them forever. Normals computed from history need
+// several years of observations.
+type HistoryConfig struct {
+	Retention Duration `json:"retention"`
+}
+
+// StreamConfig bounds the open SSE and WebSocket connections
+type StreamConfig struct {
+	MaxPerClient int `json:"max_per_client"`
+	MaxTotal     int `json:"max_total"`
+}
+
+// CORSConfig lists the origins allowed to call the API from a browser, or
+// "*" for any. CORS headers are not sent when empty.
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// RateLimitConfig limits requests per client IP. 0 disables the limit.
+type RateLimitConfig struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+// Duration is a time.Duration written as "90s" or "10m" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) duration() time.Duration {
+	return time.Duration(d)
+}
+
+// defaultConfig returns the settings used when nothing is configured
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddr: ":8080",
+		DataDir:    "data",
+		Providers:  []ProviderConfig{{Name: "caiyun"}},
+		Locations:  map[string]string{},
+		Cache: CacheConfig{
+			TTL:             Duration(5 * time.Minute),
+			RefreshInterval: Duration(10 * time.Minute),
+		},
+		History: HistoryConfig{Retention: Duration(defaultHistoryRetention)},
+		Stream:  StreamConfig{MaxPerClient: 4, MaxTotal: 1000},
+	}
+}
+
+// loadConfig builds the configuration from args (without the program name)
+// and the environment. It returns the arguments left after the flags, such
+// as a subcommand. The result still has to be validated.
+func loadConfig(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("lakelink", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON configuration file")
+	listenAddr := fs.String("listen", "", "address to listen on, e.g. :8080")
+	dataDir := fs.String("data-dir", "", "directory for persistent state")
+	cacheTTL := fs.Duration("cache-ttl", 0, "how long weather reports are reused")
+	refreshInterval := fs.Duration("refresh-interval", 0, "how often watched locations are refreshed")
+	corsOrigins := fs.String("cors-origins", "", "comma-separated origins allowed by CORS")
+	rateLimit := fs.Int("rate-limit", 0, "requests per minute per client IP")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return nil, nil, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.ListenAddr = *listenAddr
+		case "data-dir":
+			cfg.DataDir = *dataDir
+		case "cache-ttl":
+			cfg.Cache.TTL = Duration(*cacheTTL)
+		case "refresh-interval":
+			cfg.Cache.RefreshInterval = Duration(*refreshInterval)
+		case "cors-origins":
+			cfg.CORS.AllowedOrigins = splitList(*corsOrigins)
+		case "rate-limit":
+			cfg.RateLimit.RequestsPerMinute = *rateLimit
+		}
+	})
+	return cfg, fs.Args(), nil
+}
+
+// readFile merges the JSON file at path into cfg, rejecting unknown keys so
+// that typos do not go unnoticed
+func (cfg *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(cfg)
+}
+
+// applyEnv overrides cfg with the environment variables that are set. Empty
+// variables count as unset, as a blank line in .env always has.
+func (cfg *Config) applyEnv() error {
+	var errs []error
+	lookup := func(name string) (string, bool) {
+		v := os.Getenv(name)
+		return v, strings.TrimSpace(v) != ""
+	}
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(name string, dst *Duration) {
+		if v, ok := lookup(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration such as \"10m\"", name, v))
+				return
+			}
+			*dst = Duration(d)
+		}
+	}
+
+	str("LISTEN_ADDR", &cfg.ListenAddr)
+	str("DATA_DIR", &cfg.DataDir)
+	if token, ok := lookup("CAIYUN_WEATHER_TOKEN"); ok {
+		cfg.setProviderToken("caiyun", token)
+	}
+	if raw, ok := lookup("LOCATIONS"); ok {
+		locations, err := parseNamedLocations(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("LOCATIONS: %w", err))
+		}
+		if cfg.Locations == nil {
+			cfg.Locations = make(map[string]string)
+		}
+		for id, location := range locations {
+			cfg.Locations[id] = location
+		}
+	}
+	duration("WEATHER_CACHE_TTL", &cfg.Cache.TTL)
+	duration("WEATHER_REFRESH_INTERVAL", &cfg.Cache.RefreshInterval)
+	duration("HISTORY_RETENTION", &cfg.History.Retention)
+	num("STREAM_MAX_PER_CLIENT", &cfg.Stream.MaxPerClient)
+	num("STREAM_MAX_TOTAL", &cfg.Stream.MaxTotal)
+	if raw, ok := lookup("CORS_ALLOWED_ORIGINS"); ok {
+		cfg.CORS.AllowedOrigins = splitList(raw)
+	}
+	num("RATE_LIMIT_PER_MINUTE", &cfg.RateLimit.RequestsPerMinute)
+	num("RATE_LIMIT_BURST", &cfg.RateLimit.Burst)
+	str("ADMIN_TOKEN", &cfg.AdminToken)
+	str("BRIEFING_TEMPLATE_DIR", &cfg.BriefingTemplateDir)
+	str("ADVICE_RULES_FILE", &cfg.AdviceRulesFile)
+	str("POLICY_FILE", &cfg.PolicyFile)
+	return errors.Join(errs...)
+}
+
+// setProviderToken sets the token of the named provider, adding the
+// provider if it is not listed
+func (cfg *Config) setProviderToken(name, token string) {
+	for i := range cfg.Providers {
+		if cfg.Providers[i].Name == name {
+			cfg.Providers[i].Token = token
+			return
+		}
+	}
+	cfg.Providers = append(cfg.Providers, ProviderConfig{Name: name, Token: token})
+}
+
+// Validate reports every invalid setting at once. Location values are
+// normalized to geopos keys, origins lose any trailing slash and an unset
+// burst defaults to the per-minute rate. offline skips the providers, for
+// subcommands that never fetch weather.
+func (cfg *Config) Validate(offline bool) error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		fail("listen_addr: %q is not host:port", cfg.ListenAddr)
+	}
+	if cfg.DataDir == "" {
+		fail("data_dir is required")
+	}
+
+	if len(cfg.Providers) == 0 && !offline {
+		fail("providers: at least one weather provider is required")
+	}
+	for i, provider := range cfg.Providers {
+		switch provider.Name {
+		case "caiyun":
+			if provider.Token == "" && !offline {
+				fail("providers[%d]: caiyun token is required (set CAIYUN_WEATHER_TOKEN)", i)
+			}
+		default:
+			fail("providers[%d]: unknown provider %q", i, provider.Name)
+		}
+	}
+
+	for id, geopos := range cfg.Locations {
+		location, err := normalizeGeopos(geopos)
+		if err != nil {
+			fail("locations.%s: %v", id, err)
+			continue
+		}
+		cfg.Locations[id] = location
+	}
+
+	if cfg.Cache.TTL <= 0 {
+		fail("cache.ttl must be positive")
+	}
+	if cfg.Cache.RefreshInterval <= 0 {
+		fail("cache.refresh_interval must be positive")
+	}
+	if cfg.History.Retention < 0 {
+		fail("history.retention must not be negative")
+	}
+	if cfg.Stream.MaxPerClient <= 0 {
+		fail("stream.max_per_client must be positive")
+	}
+	if cfg.Stream.MaxTotal < cfg.Stream.MaxPerClient {
+		fail("stream.max_total must be at least stream.max_per_client")
+	}
+
+	for i, origin := range cfg.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" ||
+			u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			fail("cors.allowed_origins: %q is not an origin such as https://example.edu", origin)
+			continue
+		}
+		// Browsers send the Origin header as lower case scheme://host[:port]
+		cfg.CORS.AllowedOrigins[i] = u.Scheme + "://" + strings.ToLower(u.Host)
+	}
+
+	if cfg.RateLimit.RequestsPerMinute < 0 {
+		fail("rate_limit.requests_per_minute must not be negative")
+	}
+	if cfg.RateLimit.RequestsPerMinute > 0 && cfg.RateLimit.Burst <= 0 {
+		cfg.RateLimit.Burst = cfg.RateLimit.RequestsPerMinute
+	}
+	if cfg.RateLimit.Burst < 0 {
+		fail("rate_limit.burst must not be negative")
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolveLocation accepts either a configured location ID or a geopos and
+// returns the normalized geopos key
+func (cfg *Config) resolveLocation(idOrGeopos string) (string, error) {
+	if location, ok := cfg.Locations[strings.TrimSpace(idOrGeopos)]; ok {
+		return location, nil
+	}
+	location, err := normalizeGeopos(idOrGeopos)
+	if err != nil {
+		return "", fmt.Errorf("unknown location %q: not a location ID or geopos", idOrGeopos)
+	}
+	return location, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
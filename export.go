@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// exportTable is tabular data ready to be written as CSV or NDJSON. Nested
+// structs are flattened into "parent.child" columns; maps and slices are
+// kept whole.
+type exportTable struct {
+	header []string
+	rows   [][]any
+}
+
+// newExportTable derives the header from T's JSON field names and flattens
+// each record. Times are rendered in zone so that every row of an export
+// uses the same offset.
+func newExportTable[T any](records []T, zone *time.Location) exportTable {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	table := exportTable{header: exportColumns(t, "")}
+	for _, record := range records {
+		table.rows = append(table.rows, exportValues(reflect.ValueOf(record), t, zone, nil))
+	}
+	return table
+}
+
+// exportColumns lists the flattened column names of t
+func exportColumns(t reflect.Type, prefix string) []string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return []string{strings.TrimSuffix(prefix, ".")}
+	}
+
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := exportFieldName(field)
+		if !ok {
+			continue
+		}
+		columns = append(columns, exportColumns(field.Type, prefix+name+".")...)
+	}
+	return columns
+}
+
+// exportValues flattens v in the same order as exportColumns. v may be the
+// zero Value, e.g. for a nil pointer, in which case every column is nil.
+func exportValues(v reflect.Value, t reflect.Type, zone *time.Location, out []any) []any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		if v.IsValid() {
+			if v.IsNil() {
+				v = reflect.Value{}
+			} else {
+				v = v.Elem()
+			}
+		}
+	}
+
+	if t.Kind() != reflect.Struct || t == timeType {
+		if !v.IsValid() {
+			return append(out, nil)
+		}
+		if t == timeType {
+			return append(out, v.Interface().(time.Time).In(zone))
+		}
+		return append(out, v.Interface())
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := exportFieldName(field); !ok {
+			continue
+		}
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+		out = exportValues(fv, field.Type, zone, out)
+	}
+	return out
+}
+
+func exportFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// exportFormat reads the format query parameter, defaulting to json
+func exportFormat(c *gin.Context) (string, bool) {
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	switch format {
+	case "json", "csv", "ndjson":
+		return format, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported format %q, expected json, csv or ndjson", format)})
+	return "", false
+}
+
+// exportFilename builds a download name such as
+// "hourly-116.3000_39.9000-20261019.csv"
+func exportFilename(kind, location string, at time.Time, format string) string {
+	safe := strings.NewReplacer(",", "_", "/", "_", " ", "_").Replace(location)
+	return fmt.Sprintf("%s-%s-%s.%s", kind, safe, at.Format("20060102"), format)
+}
+
+// writeExport streams table as CSV or NDJSON with a download filename.
+// NDJSON objects use the same flattened keys as the CSV header.
+func writeExport(c *gin.Context, format, filename string, table exportTable) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		w := csv.NewWriter(c.Writer)
+		w.Write(table.header)
+		for i, row := range table.rows {
+			record := make([]string, len(row))
+			for j, value := range row {
+				record[j] = formatCSVValue(value)
+			}
+			w.Write(record)
+			if i%100 == 99 {
+				w.Flush()
+				c.Writer.Flush()
+			}
+		}
+		w.Flush()
+
+	case "ndjson":
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		for _, row := range table.rows {
+			var buf bytes.Buffer
+			buf.WriteByte('{')
+			for j, value := range row {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				if t, ok := value.(time.Time); ok {
+					value = formatCSVValue(t)
+				}
+				key, _ := json.Marshal(table.header[j])
+				val, err := json.Marshal(value)
+				if err != nil {
+					val = []byte("null")
+				}
+				buf.Write(key)
+				buf.WriteByte(':')
+				buf.Write(val)
+			}
+			buf.WriteString("}\n")
+			c.Writer.Write(buf.Bytes())
+		}
+		c.Writer.Flush()
+	}
+}
+
+func formatCSVValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
+
+// historyBucketTable flattens aggregated history into columns such as
+// "temperature.min"
+func historyBucketTable(buckets []HistoryBucket, fields, aggregates []string, zone *time.Location) exportTable {
+	table := exportTable{header: []string{"start", "end", "count"}}
+	for _, field := range fields {
+		for _, agg := range aggregates {
+			table.header = append(table.header, field+"."+agg)
+		}
+	}
+	for _, bucket := range buckets {
+		row := []any{bucket.Start.In(zone), bucket.End.In(zone), bucket.Count}
+		for _, field := range fields {
+			for _, agg := range aggregates {
+				row = append(row, bucket.Fields[field][agg])
+			}
+		}
+		table.rows = append(table.rows, row)
+	}
+	return table
+}
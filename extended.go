@@ -0,0 +1,167 @@
+package main
+
+// ExtendedWeatherResponse is the light model plus every series Caiyun
+// reports. The extended current, hourly and daily entries replace those of
+// the light model in JSON. Units: pressure in Pa, visibility in km, dswrf
+// (downward shortwave radiation) in W/m², cloud rate from 0 to 1.
+type ExtendedWeatherResponse struct {
+	LightWeatherResponse
+	Current ExtendedCurrentWeather  `json:"current"`
+	Hourly  []ExtendedHourlyWeather `json:"hourly"`
+	Daily   []ExtendedDailyWeather  `json:"daily"`
+}
+
+// ExtendedCurrentWeather adds the remaining realtime fields
+type ExtendedCurrentWeather struct {
+	CurrentWeather
+	Cloudrate     float64           `json:"cloudrate"`
+	Dswrf         float64           `json:"dswrf"`
+	NearestRain   NearestRainInfo   `json:"nearest_precipitation"`
+	AirPollutants AirPollutantsInfo `json:"air_pollutants"`
+}
+
+// NearestRainInfo is the closest precipitation detected by radar
+type NearestRainInfo struct {
+	Distance  float64 `json:"distance"` // km
+	Intensity float64 `json:"intensity"`
+}
+
+// AirPollutantsInfo holds pollutant concentrations in μg/m³ (CO in mg/m³)
+type AirPollutantsInfo struct {
+	PM25   float64 `json:"pm25"`
+	PM10   float64 `json:"pm10"`
+	O3     float64 `json:"o3"`
+	SO2    float64 `json:"so2"`
+	NO2    float64 `json:"no2"`
+	CO     float64 `json:"co"`
+	AQIUSA int     `json:"aqi_usa"`
+}
+
+// ExtendedHourlyWeather adds the remaining hourly series
+type ExtendedHourlyWeather struct {
+	HourlyWeather
+	WindDirection float64 `json:"wind_direction"`
+	Cloudrate     float64 `json:"cloudrate"`
+	Pressure      float64 `json:"pressure"`
+	Visibility    float64 `json:"visibility"`
+	Dswrf         float64 `json:"dswrf"`
+	AQI           int     `json:"aqi"`
+	PM25          float64 `json:"pm25"`
+}
+
+// RangeInfo is the min, max and average of a daily series
+type RangeInfo struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+// ExtendedDailyWeather adds the remaining daily ranges
+type ExtendedDailyWeather struct {
+	DailyWeather
+	Humidity   *RangeInfo `json:"humidity"`
+	Cloudrate  *RangeInfo `json:"cloudrate"`
+	Pressure   *RangeInfo `json:"pressure"`
+	Visibility *RangeInfo `json:"visibility"`
+	Dswrf      *RangeInfo `json:"dswrf"`
+	AQI        *RangeInfo `json:"aqi"`
+	PM25       *RangeInfo `json:"pm25"`
+}
+
+// extendLightModel adds the series the light model leaves out. light must
+// have been converted from full; entries are matched by time so that
+// entries skipped for bad timestamps stay aligned.
+func extendLightModel(full *CaiyunAPIResponse, light *LightWeatherResponse) *ExtendedWeatherResponse {
+	times := newCaiyunTimes(full)
+	rt := full.Result.Realtime
+	ext := &ExtendedWeatherResponse{
+		LightWeatherResponse: *light,
+		Current: ExtendedCurrentWeather{
+			CurrentWeather: light.Current,
+			Cloudrate:      rt.Cloudrate,
+			Dswrf:          rt.Dswrf,
+			NearestRain: NearestRainInfo{
+				Distance:  rt.Precipitation.Nearest.Distance,
+				Intensity: rt.Precipitation.Nearest.Intensity,
+			},
+			AirPollutants: AirPollutantsInfo{
+				PM25:   rt.AirQuality.PM25,
+				PM10:   rt.AirQuality.PM10,
+				O3:     rt.AirQuality.O3,
+				SO2:    rt.AirQuality.SO2,
+				NO2:    rt.AirQuality.NO2,
+				CO:     rt.AirQuality.CO,
+				AQIUSA: int(rt.AirQuality.AQI.USA),
+			},
+		},
+		Hourly: []ExtendedHourlyWeather{},
+		Daily:  []ExtendedDailyWeather{},
+	}
+
+	hourly := full.Result.Hourly
+	hourIndex := make(map[int64]int)
+	for i, point := range hourly.Temperature {
+		if t, ok := times.parse("", point.Datetime); ok {
+			hourIndex[t.Unix()] = i
+		}
+	}
+	for _, hour := range light.Hourly {
+		entry := ExtendedHourlyWeather{HourlyWeather: hour}
+		i, ok := hourIndex[hour.Time.Unix()]
+		if ok {
+			if i < len(hourly.Wind) {
+				entry.WindDirection = hourly.Wind[i].Direction
+			}
+			if i < len(hourly.Cloudrate) {
+				entry.Cloudrate = hourly.Cloudrate[i].Value
+			}
+			if i < len(hourly.Pressure) {
+				entry.Pressure = hourly.Pressure[i].Value
+			}
+			if i < len(hourly.Visibility) {
+				entry.Visibility = hourly.Visibility[i].Value
+			}
+			if i < len(hourly.Dswrf) {
+				entry.Dswrf = hourly.Dswrf[i].Value
+			}
+			if i < len(hourly.AirQuality.AQI) {
+				entry.AQI = int(hourly.AirQuality.AQI[i].Value.CHN)
+			}
+			if i < len(hourly.AirQuality.PM25) {
+				entry.PM25 = hourly.AirQuality.PM25[i].Value
+			}
+		}
+		ext.Hourly = append(ext.Hourly, entry)
+	}
+
+	daily := full.Result.Daily
+	dayIndex := make(map[int64]int)
+	for i, point := range daily.Temperature {
+		if t, ok := times.parse("", point.Date); ok {
+			dayIndex[t.Unix()] = i
+		}
+	}
+	rangeAt := func(series []DailyRangeData, i int) *RangeInfo {
+		if i >= len(series) {
+			return nil
+		}
+		return &RangeInfo{Min: series[i].Min, Max: series[i].Max, Avg: series[i].Avg}
+	}
+	for _, day := range light.Daily {
+		entry := ExtendedDailyWeather{DailyWeather: day}
+		if i, ok := dayIndex[day.Date.Unix()]; ok {
+			entry.Humidity = rangeAt(daily.Humidity, i)
+			entry.Cloudrate = rangeAt(daily.Cloudrate, i)
+			entry.Pressure = rangeAt(daily.Pressure, i)
+			entry.Visibility = rangeAt(daily.Visibility, i)
+			entry.Dswrf = rangeAt(daily.Dswrf, i)
+			entry.PM25 = rangeAt(daily.AirQuality.PM25, i)
+			if i < len(daily.AirQuality.AQI) {
+				aqi := daily.AirQuality.AQI[i]
+				entry.AQI = &RangeInfo{Min: aqi.Min.CHN, Max: aqi.Max.CHN, Avg: aqi.Avg.CHN}
+			}
+		}
+		ext.Daily = append(ext.Daily, entry)
+	}
+	return ext
+}
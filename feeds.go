@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// feedAlert is an alert prepared for a syndication feed
+type feedAlert struct {
+	ID          string
+	Title       string
+	Description string
+	Source      string
+	Category    string
+	PublishedAt time.Time
+}
+
+// feedAlerts converts the alerts in a Caiyun response, newest first, with
+// titles in lang. Alerts without a publication time are dated at the
+// response's time.
+func feedAlerts(resp *CaiyunAPIResponse, lang Lang) []feedAlert {
+	var alerts []feedAlert
+	for _, alert := range convertAlerts(resp) {
+		if alert.ID == "" {
+			continue
+		}
+		publishedAt := alert.PublishedAt
+		if publishedAt.IsZero() {
+			publishedAt = responseTime(resp)
+		}
+		class := AlertClass{Type: alert.Type, Color: alert.Color, Severity: alert.Severity}
+		alerts = append(alerts, feedAlert{
+			ID:          alert.ID,
+			Title:       localizedAlertTitle(alert.Title, alert.Location, class, lang),
+			Description: alert.Description,
+			Source:      alert.Source,
+			Category:    alert.Type.Name(lang),
+			PublishedAt: publishedAt.UTC(),
+		})
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].PublishedAt.After(alerts[j].PublishedAt)
+	})
+	return alerts
+}
+
+// responseTime returns the server time of resp, or now when it has none
+func responseTime(resp *CaiyunAPIResponse) time.Time {
+	if resp.ServerTime == 0 {
+		return time.Now()
+	}
+	return time.Unix(resp.ServerTime, 0)
+}
+
+// localizedAlertTitle keeps the official title in Chinese and builds one such
+// as "Blue Gale Warning (Level IV/Minor) - 北京市" in English
+func localizedAlertTitle(title, location string, class AlertClass, lang Lang) string {
+	if lang == LangZH && title != "" {
+		return title
+	}
+	s := fmt.Sprintf("%s %s Warning", class.Color.Name(lang), class.Type.Name(lang))
+	if lang == LangZH {
+		s = class.Color.Name(lang) + class.Type.Name(lang) + "预警"
+	}
+	if class.Severity != AlertSeverityUnknown {
+		s += " (" + class.Severity.Label(lang) + ")"
+	}
+	if location != "" {
+		s += " - " + location
+	}
+	return s
+}
+
+// alertFeedTitle names the feed of a location
+func alertFeedTitle(location string, lang Lang) string {
+	return localized{"天气预警 ", "Weather alerts for "}.in(lang) + location
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang    string      `xml:"xml:lang,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published"`
+	Author    *atomAuthor   `xml:"author,omitempty"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Content   atomContent   `xml:"content"` // required, as entries have no alternate link
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// buildAtomFeed renders alerts as an Atom 1.0 document. Entry IDs are
+// derived from the Caiyun alert ID so readers never show an alert twice.
+func buildAtomFeed(location, selfURL string, alerts []feedAlert, updated time.Time, lang Lang) ([]byte, error) {
+	feed := atomFeed{
+		Lang:    string(lang),
+		ID:      "urn:lakelink:alerts:" + location,
+		Title:   alertFeedTitle(location, lang),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Link:    atomLink{Rel: "self", Href: selfURL},
+		Author:  atomAuthor{Name: "LakeLink"},
+	}
+	for _, alert := range alerts {
+		entry := atomEntry{
+			ID:        "urn:lakelink:alert:" + alert.ID,
+			Title:     alert.Title,
+			Updated:   alert.PublishedAt.Format(time.RFC3339),
+			Published: alert.PublishedAt.Format(time.RFC3339),
+			Content:   atomContent{Type: "text", Value: alert.Description},
+		}
+		if alert.Source != "" {
+			entry.Author = &atomAuthor{Name: alert.Source}
+		}
+		if alert.Category != "" {
+			entry.Category = &atomCategory{Term: alert.Category}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalFeed(feed)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomSelf  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type atomSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	Category    string  `xml:"category,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// buildRSSFeed renders alerts as an RSS 2.0 document
+func buildRSSFeed(location, selfURL string, alerts []feedAlert, updated time.Time, lang Lang) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         alertFeedTitle(location, lang),
+			Link:          selfURL,
+			Description:   alertFeedTitle(location, lang),
+			Language:      localized{"zh-cn", "en"}.in(lang),
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			Self:          atomSelf{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	for _, alert := range alerts {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       alert.Title,
+			Description: alert.Description,
+			Category:    alert.Category,
+			GUID:        rssGUID{Value: "urn:lakelink:alert:" + alert.ID},
+			PubDate:     alert.PublishedAt.Format(time.RFC1123Z),
+		})
+	}
+	return marshalFeed(feed)
+}
+
+func marshalFeed(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
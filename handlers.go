@@ -1,72 +1,780 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/subtle"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
 )
 
-// GetWeatherHandler handles the weather API request
-func GetWeatherHandler(c *gin.Context) {
+const (
+	streamHeartbeatInterval = 15 * time.Second
+	streamMaxDuration       = time.Hour
+	streamRetryMillis       = 5000
+)
+
+// GetWeatherHandler handles the weather API request. detail=light returns
+// the condensed LightWeatherResponse instead of the raw Caiyun sections and
+// detail=extended the light model with every parsed series.
+func (s *Server) GetWeatherHandler(c *gin.Context) {
 	geopos := c.Query("geopos")
 	if geopos == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "geopos parameter is required"})
 		return
 	}
 
-	caiyunWeatherToken := os.Getenv("CAIYUN_WEATHER_TOKEN")
-	if caiyunWeatherToken == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "CAIYUN_WEATHER_TOKEN not set"})
+	location, err := normalizeGeopos(geopos)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	caiyunURL := fmt.Sprintf("https://api.caiyunapp.com/v2.6/%s/%s/weather?alert=true&dailysteps=1&hourlysteps=24", caiyunWeatherToken, geopos)
+	caiyunResp, err := s.cache.Get(location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Print(err)
+		return
+	}
 
-	log.Printf("Requesting weather data from Caiyun API: %s", caiyunURL)
+	switch c.Query("detail") {
+	case "light":
+		light := ConvertToLightModel(caiyunResp, parseLang(c.Query("lang")))
+		s.climate.applyAnomalies(location, light)
+		c.JSON(http.StatusOK, light)
+		return
+	case "extended":
+		light := ConvertToLightModel(caiyunResp, parseLang(c.Query("lang")))
+		s.climate.applyAnomalies(location, light)
+		c.JSON(http.StatusOK, extendLightModel(caiyunResp, light))
+		return
+	}
 
-	resp, err := http.Get(caiyunURL)
+	weatherData := gin.H{
+		"realtime": caiyunResp.Result.Realtime,
+		"alert":    caiyunResp.Result.Alert,
+		// "minutely": caiyunResp.Result.Minutely,
+		"hourly": caiyunResp.Result.Hourly,
+		"daily":  caiyunResp.Result.Daily,
+	}
+
+	c.JSON(http.StatusOK, weatherData)
+}
+
+// GetHourlyWeatherHandler returns the hourly forecast of the light model as
+// JSON, CSV or NDJSON (format=json|csv|ndjson)
+func (s *Server) GetHourlyWeatherHandler(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	location, light, zone, ok := s.lightWeatherParam(c)
+	if !ok {
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{"location": location, "hourly": light.Hourly})
+		return
+	}
+	writeExport(c, format, exportFilename("hourly", location, light.LastUpdated.In(zone), format), newExportTable(light.Hourly, zone))
+}
+
+// GetDailyWeatherHandler returns the daily forecast of the light model as
+// JSON, CSV or NDJSON (format=json|csv|ndjson)
+func (s *Server) GetDailyWeatherHandler(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	location, light, zone, ok := s.lightWeatherParam(c)
+	if !ok {
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{"location": location, "daily": light.Daily})
+		return
+	}
+	writeExport(c, format, exportFilename("daily", location, light.LastUpdated.In(zone), format), newExportTable(light.Daily, zone))
+}
+
+// GetWeatherCalendarHandler serves the daily forecast and active alerts as
+// an iCalendar feed that calendar apps can subscribe to
+func (s *Server) GetWeatherCalendarHandler(c *gin.Context) {
+	location, light, zone, ok := s.lightWeatherParam(c)
+	if !ok {
+		return
+	}
+
+	filename := exportFilename("weather", location, light.LastUpdated.In(zone), "ics")
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(buildWeatherCalendar(location, light, zone)))
+}
+
+// GetWeatherBriefingHandler returns a natural-language briefing for a
+// forecast day (day=0 is today) in the requested language
+func (s *Server) GetWeatherBriefingHandler(c *gin.Context) {
+	day, err := strconv.Atoi(c.DefaultQuery("day", "0"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch weather data: %v", err)})
-		log.Printf("Failed to fetch weather data: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "day must be an integer"})
+		return
+	}
+	location, light, zone, ok := s.lightWeatherParam(c)
+	if !ok {
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	briefing, err := s.briefings.Generate(location, light, zone, day, parseLang(c.Query("lang")))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read response body: %v", err)})
-		log.Printf("Failed to read response body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, briefing)
+}
+
+// GetWeatherAdviceHandler recommends umbrella, clothing and activity times
+// for the next hours (hours=1..24, default 12)
+func (s *Server) GetWeatherAdviceHandler(c *gin.Context) {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "12"))
+	if err != nil || hours < 1 || hours > 24 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hours must be between 1 and 24"})
+		return
+	}
+	location, ok := s.locationParam(c)
+	if !ok {
 		return
 	}
 
-	var caiyunResp CaiyunAPIResponse
-	err = json.Unmarshal(body, &caiyunResp)
+	caiyunResp, err := s.cache.Get(location)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse weather data: %v", err)})
-		log.Printf("Failed to parse weather data: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Print(err)
 		return
 	}
 
-	if caiyunResp.Status != "ok" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Caiyun API returned status: %s; msg=%s", caiyunResp.Status, caiyunResp.ErrorMsg)})
-		log.Printf("Caiyun API returned status: %s", caiyunResp.Status)
+	advice, err := s.advice.Advise(location, adviceHours(caiyunResp, hours), parseLang(c.Query("lang")))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, advice)
+}
 
-	weatherData := gin.H{
-		"realtime": caiyunResp.Result.Realtime,
-		"alert":    caiyunResp.Result.Alert,
-		// "minutely": caiyunResp.Result.Minutely,
-		"hourly": caiyunResp.Result.Hourly,
-		"daily":  caiyunResp.Result.Daily,
+// GetWeatherWindowHandler summarizes the forecast for an event from start
+// to end (default the next two hours) and scores it as go or no-go
+func (s *Server) GetWeatherWindowHandler(c *gin.Context) {
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+	start, end, ok := windowParams(c, s.history.Zone(location), 2*time.Hour)
+	if !ok {
+		return
 	}
 
-	c.JSON(http.StatusOK, weatherData)
+	caiyunResp, err := s.cache.Get(location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Print(err)
+		return
+	}
+	lang := parseLang(c.Query("lang"))
+	ext := extendLightModel(caiyunResp, ConvertToLightModel(caiyunResp, lang))
+
+	window, err := forecastWindow(location, ext, start, end, lang)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, window)
+}
+
+// GetPolicyEvaluationHandler decides whether an outdoor event may go ahead
+// in the window from start to end (default the next hour). policy limits the
+// evaluation to one configured policy.
+func (s *Server) GetPolicyEvaluationHandler(c *gin.Context) {
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+	selected, ok := s.policies.Select(c.Query("policy"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown policy"})
+		return
+	}
+	start, end, ok := windowParams(c, s.history.Zone(location), time.Hour)
+	if !ok {
+		return
+	}
+
+	caiyunResp, err := s.cache.Get(location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Print(err)
+		return
+	}
+	lang := parseLang(c.Query("lang"))
+	light := ConvertToLightModel(caiyunResp, lang)
+	ext := extendLightModel(caiyunResp, light)
+
+	evaluation, err := evaluatePolicies(location, selected, policySamples(ext, start, end), light.Alerts, start, end, lang)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, evaluation)
+}
+
+// GetAstroHandler returns sun and moon data computed locally for a location
+// and date (YYYY-MM-DD, default today)
+func (s *Server) GetAstroHandler(c *gin.Context) {
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+	lng, lat, err := parseGeopos(location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zone := s.history.Zone(location)
+	if resp := s.cache.Peek(location); resp != nil {
+		zone = locationZone(resp)
+	}
+
+	day := time.Now().In(zone)
+	if raw := c.Query("date"); raw != "" {
+		if day, err = time.ParseInLocation("2006-01-02", raw, zone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, computeAstro(lat, lng, day, zone, parseLang(c.Query("lang"))))
+}
+
+// GetSolarHandler estimates the output of a PV system from the irradiance
+// forecast. capacity_kw is required; tilt defaults to 25° and azimuth to 180°
+// (facing south).
+func (s *Server) GetSolarHandler(c *gin.Context) {
+	var system PVSystem
+	var err error
+	for _, param := range []struct {
+		name, fallback string
+		dst            *float64
+	}{
+		{"capacity_kw", "", &system.CapacityKW},
+		{"tilt", "25", &system.Tilt},
+		{"azimuth", "180", &system.Azimuth},
+	} {
+		raw := c.DefaultQuery(param.name, param.fallback)
+		if raw == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param.name + " is required"})
+			return
+		}
+		if *param.dst, err = strconv.ParseFloat(raw, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param.name + " must be a number"})
+			return
+		}
+	}
+	if err := system.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+
+	caiyunResp, err := s.cache.Get(location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Print(err)
+		return
+	}
+
+	forecast, err := estimateSolar(location, caiyunResp, system)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, forecast)
+}
+
+// GetWeatherHistoryHandler returns recorded observations for a location.
+// Without interval the raw observations are returned; with interval they are
+// grouped into buckets with the aggregates listed in agg (min, max, avg,
+// sum). The precipitation sum is the accumulated rainfall in mm. format=csv
+// and format=ndjson export the same rows.
+func (s *Server) GetWeatherHistoryHandler(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+	zone := s.history.Zone(location)
+	from, to, ok := timeRangeParams(c, zone, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	observations := s.history.Query(location, from, to)
+	filename := exportFilename("history", location, from, format)
+
+	if c.Query("interval") == "" {
+		if format != "json" {
+			writeExport(c, format, filename, newExportTable(observations, zone))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"location":     location,
+			"from":         from,
+			"to":           to,
+			"observations": observations,
+		})
+		return
+	}
+
+	interval, err := parseInterval(c.Query("interval"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var fields []string
+	for _, field := range historyFields {
+		fields = append(fields, field.name)
+	}
+	if raw := c.Query("fields"); raw != "" {
+		requested := strings.Split(raw, ",")
+		for _, name := range requested {
+			if !slices.Contains(fields, name) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown field %q", name)})
+				return
+			}
+		}
+		fields = requested
+	}
+	aggregates := historyAggregates
+	if raw := c.Query("agg"); raw != "" {
+		aggregates = strings.Split(raw, ",")
+		for _, agg := range aggregates {
+			if !slices.Contains(historyAggregates, agg) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown aggregate %q, expected min, max, avg or sum", agg)})
+				return
+			}
+		}
+	}
+
+	buckets := aggregateObservations(observations, interval, fields, aggregates)
+	if format != "json" {
+		writeExport(c, format, filename, historyBucketTable(buckets, fields, aggregates, zone))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"location": location,
+		"from":     from,
+		"to":       to,
+		"interval": c.Query("interval"),
+		"buckets":  buckets,
+	})
+}
+
+// GetVerificationHandler reports how well stored forecasts for a location
+// matched the observations recorded afterwards, by lead time
+func (s *Server) GetVerificationHandler(c *gin.Context) {
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+	from, to, ok := timeRangeParams(c, s.history.Zone(location), 7*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	observations := s.history.Query(location, from.Add(-maxObservationOffset), to.Add(maxObservationOffset))
+	runs := s.forecasts.Runs(location, to)
+
+	c.JSON(http.StatusOK, verifyForecasts(location, runs, observations, from, to))
+}
+
+// StreamWeatherHandler pushes realtime updates and alert changes for one
+// location as Server-Sent Events. Clients reconnecting with Last-Event-ID
+// receive the events they missed.
+func (s *Server) StreamWeatherHandler(c *gin.Context) {
+	location, ok := s.locationParam(c)
+	if !ok {
+		return
+	}
+
+	release, err := s.hub.Acquire(c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	sub := newStreamSubscriber(16)
+	s.hub.Subscribe(location, sub)
+	defer s.hub.Unsubscribe(location, sub)
+	s.cache.Watch(location)
+	defer s.cache.Unwatch(location)
+
+	// Replay missed events on resume, otherwise start from the latest report
+	var backlog []streamEvent
+	resumed := false
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		if id, err := strconv.ParseInt(lastEventID, 10, 64); err == nil {
+			backlog, resumed = s.hub.Since(location, id)
+		}
+	}
+	if !resumed {
+		if latest, ok := s.hub.Latest(location); ok {
+			backlog = []streamEvent{latest}
+		} else {
+			go func() {
+				if _, err := s.cache.Get(location); err != nil {
+					log.Printf("Failed to load weather for stream %s: %v", location, err)
+				}
+			}()
+		}
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	deadline := time.After(streamMaxDuration)
+	first := true
+
+	writeEvent := func(event streamEvent) {
+		msg := sse.Event{Id: strconv.FormatInt(event.ID, 10), Event: event.Name, Data: event.Data}
+		if first {
+			msg.Retry = streamRetryMillis
+			first = false
+		}
+		c.Render(-1, msg)
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		if len(backlog) > 0 {
+			for _, event := range backlog {
+				writeEvent(event)
+			}
+			backlog = nil
+			return true
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-deadline:
+			// Close long-lived streams so clients reconnect and rebalance
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+			return true
+		case <-sub.Dropped:
+			// Too far behind: end the stream so the client reconnects
+			// with Last-Event-ID and replays what it missed
+			return false
+		case event := <-sub.Events:
+			writeEvent(event)
+			return true
+		}
+	})
+}
+
+// WeatherSocketHandler upgrades to a WebSocket over which clients subscribe
+// to several locations at once
+func (s *Server) WeatherSocketHandler(c *gin.Context) {
+	release, err := s.hub.Acquire(c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	server := websocket.Server{Handler: s.serveWeatherSocket}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// GetAlertFeedHandler serves the alerts in effect for a location as an Atom
+// or RSS feed, depending on the route
+func (s *Server) GetAlertFeedHandler(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		location, ok := s.locationParam(c)
+		if !ok {
+			return
+		}
+		lang := parseLang(c.Query("lang"))
+
+		caiyunResp, err := s.cache.Get(location)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Print(err)
+			return
+		}
+
+		alerts := feedAlerts(caiyunResp, lang)
+		updated := responseTime(caiyunResp)
+		if len(alerts) > 0 {
+			updated = alerts[0].PublishedAt
+		}
+
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		selfURL := scheme + "://" + c.Request.Host + c.Request.URL.RequestURI()
+
+		var data []byte
+		var contentType string
+		if kind == "rss" {
+			data, err = buildRSSFeed(location, selfURL, alerts, updated, lang)
+			contentType = "application/rss+xml; charset=utf-8"
+		} else {
+			data, err = buildAtomFeed(location, selfURL, alerts, updated, lang)
+			contentType = "application/atom+xml; charset=utf-8"
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Print(err)
+			return
+		}
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
+
+// GetAlertChangesHandler returns alerts issued, changed or lifted since a
+// cursor. since accepts either a sequence number from a previous response's
+// "next" field or an RFC3339 timestamp. "truncated" is set when changes
+// after the cursor have been discarded.
+func (s *Server) GetAlertChangesHandler(c *gin.Context) {
+	var seq int64
+	var since time.Time
+
+	if raw := c.Query("since"); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			seq = n
+		} else if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			since = t
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid since %q: expected a sequence number or RFC3339 time", raw)})
+			return
+		}
+	}
+
+	location := ""
+	if geopos := c.Query("location"); geopos != "" {
+		var err error
+		location, err = normalizeGeopos(geopos)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	changes, next, truncated := s.alerts.ChangesSince(location, seq, since)
+	c.JSON(http.StatusOK, gin.H{
+		"changes":   changes,
+		"next":      next,
+		"truncated": truncated,
+	})
+}
+
+// CreateSubscriptionHandler registers a webhook subscription. The response is
+// the only time the signing secret and the management token are returned.
+func (s *Server) CreateSubscriptionHandler(c *gin.Context) {
+	var sub Subscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid subscription: %v", err)})
+		return
+	}
+
+	created, err := s.subscriptions.Add(sub)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.cache.Watch(created.Location)
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListSubscriptionsHandler lists all webhook subscriptions. It requires the
+// admin token.
+func (s *Server) ListSubscriptionsHandler(c *gin.Context) {
+	if !s.isAdmin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "admin token required"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"subscriptions": s.subscriptions.List()})
+}
+
+// GetSubscriptionHandler returns one webhook subscription
+func (s *Server) GetSubscriptionHandler(c *gin.Context) {
+	if !s.authorizeSubscription(c) {
+		return
+	}
+	sub, _ := s.subscriptions.Get(c.Param("id"))
+	c.JSON(http.StatusOK, sub)
+}
+
+// DeleteSubscriptionHandler removes a webhook subscription
+func (s *Server) DeleteSubscriptionHandler(c *gin.Context) {
+	if !s.authorizeSubscription(c) {
+		return
+	}
+	sub, ok := s.subscriptions.Remove(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+	s.cache.Unwatch(sub.Location)
+	c.Status(http.StatusNoContent)
+}
+
+// GetSubscriptionDeliveriesHandler returns the delivery log of a subscription
+func (s *Server) GetSubscriptionDeliveriesHandler(c *gin.Context) {
+	if !s.authorizeSubscription(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": s.subscriptions.Deliveries(c.Param("id"))})
+}
+
+// authorizeSubscription checks that the subscription in the id parameter
+// exists and that the request carries its management token or the admin
+// token, writing a 404 or 401 response otherwise
+func (s *Server) authorizeSubscription(c *gin.Context) bool {
+	id := c.Param("id")
+	if _, ok := s.subscriptions.Get(id); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return false
+	}
+	if !s.isAdmin(c) && !s.subscriptions.Authorize(id, bearerToken(c)) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "subscription token required"})
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether the request carries the configured admin token
+func (s *Server) isAdmin(c *gin.Context) bool {
+	token := bearerToken(c)
+	return s.config.AdminToken != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// locationParam resolves the location query parameter, which may be a
+// location ID or a geopos, writing a 400 response when it is missing or invalid
+func (s *Server) locationParam(c *gin.Context) (string, bool) {
+	if c.Query("location") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "location parameter is required"})
+		return "", false
+	}
+	location, err := s.config.resolveLocation(c.Query("location"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return location, true
+}
+
+// timeRangeParams reads the from and to query parameters. to defaults to now
+// and from to span before to.
+func timeRangeParams(c *gin.Context, zone *time.Location, span time.Duration) (from, to time.Time, ok bool) {
+	var err error
+	to = time.Now().In(zone)
+	if raw := c.Query("to"); raw != "" {
+		if to, err = parseTimeParam(raw, zone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return from, to, false
+		}
+	}
+	from = to.Add(-span)
+	if raw := c.Query("from"); raw != "" {
+		if from, err = parseTimeParam(raw, zone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return from, to, false
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// windowParams reads an upcoming time window from the start and end query
+// parameters. start defaults to now and end to span after start.
+func windowParams(c *gin.Context, zone *time.Location, span time.Duration) (start, end time.Time, ok bool) {
+	var err error
+	start = time.Now().In(zone)
+	if raw := c.Query("start"); raw != "" {
+		if start, err = parseTimeParam(raw, zone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return start, end, false
+		}
+	}
+	end = start.Add(span)
+	if raw := c.Query("end"); raw != "" {
+		if end, err = parseTimeParam(raw, zone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return start, end, false
+		}
+	}
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be before end"})
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// lightWeatherParam loads the light model for the location query parameter,
+// writing an error response on failure
+func (s *Server) lightWeatherParam(c *gin.Context) (string, *LightWeatherResponse, *time.Location, bool) {
+	location, ok := s.locationParam(c)
+	if !ok {
+		return "", nil, nil, false
+	}
+	caiyunResp, err := s.cache.Get(location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Print(err)
+		return "", nil, nil, false
+	}
+	light := ConvertToLightModel(caiyunResp, parseLang(c.Query("lang")))
+	s.climate.applyAnomalies(location, light)
+	return location, light, locationZone(caiyunResp), true
 }
 
 // HelloHandler handles the root endpoint
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPrecipitationGap caps how long a single precipitation intensity
+	// sample is assumed to last when accumulating rainfall
+	maxPrecipitationGap = time.Hour
+
+	// defaultHistoryRetention keeps a year more than normals need
+	defaultHistoryRetention = (minNormalYears + 1) * 366 * 24 * time.Hour
+
+	// historyCompactSlack is how far past the retention the oldest
+	// observation may be before a location's file is rewritten, so that
+	// files are compacted about once a day rather than on every append
+	historyCompactSlack = 24 * time.Hour
+)
+
+// Observation is one recorded realtime report
+type Observation struct {
+	Time                time.Time `json:"time"`
+	Temperature         float64   `json:"temperature"`
+	ApparentTemperature float64   `json:"apparent_temperature"`
+	Humidity            float64   `json:"humidity"`
+	Pressure            float64   `json:"pressure"`
+	Visibility          float64   `json:"visibility"`
+	Cloudrate           float64   `json:"cloudrate"`
+	Dswrf               float64   `json:"dswrf"`
+	WindSpeed           float64   `json:"wind_speed"`
+	WindDirection       float64   `json:"wind_direction"`
+	Precipitation       float64   `json:"precipitation"` // intensity, mm/h
+	AQI                 float64   `json:"aqi"`
+	PM25                float64   `json:"pm25"`
+	Condition           string    `json:"condition"`
+}
+
+// historyFields lists the numeric observation fields that can be aggregated
+var historyFields = []struct {
+	name  string
+	value func(Observation) float64
+}{
+	{"temperature", func(o Observation) float64 { return o.Temperature }},
+	{"apparent_temperature", func(o Observation) float64 { return o.ApparentTemperature }},
+	{"humidity", func(o Observation) float64 { return o.Humidity }},
+	{"pressure", func(o Observation) float64 { return o.Pressure }},
+	{"visibility", func(o Observation) float64 { return o.Visibility }},
+	{"cloudrate", func(o Observation) float64 { return o.Cloudrate }},
+	{"dswrf", func(o Observation) float64 { return o.Dswrf }},
+	{"wind_speed", func(o Observation) float64 { return o.WindSpeed }},
+	{"precipitation", func(o Observation) float64 { return o.Precipitation }},
+	{"aqi", func(o Observation) float64 { return o.AQI }},
+	{"pm25", func(o Observation) float64 { return o.PM25 }},
+}
+
+var historyAggregates = []string{"min", "max", "avg", "sum"}
+
+// HistoryBucket aggregates the observations of one interval. Fields maps a
+// field name to its aggregates, e.g. {"temperature": {"min": 12.5}}.
+type HistoryBucket struct {
+	Start  time.Time                     `json:"start"`
+	End    time.Time                     `json:"end"`
+	Count  int                           `json:"count"`
+	Fields map[string]map[string]float64 `json:"fields"`
+}
+
+// HistoryStore is an embedded time-series store of realtime observations.
+// Each location is an append-only NDJSON file under dir, loaded into memory
+// at startup. Observations older than the retention are dropped and the file
+// rewritten without them.
+type HistoryStore struct {
+	dir       string
+	retention time.Duration // 0 keeps everything
+
+	mu     sync.RWMutex
+	series map[string][]Observation
+}
+
+// newHistoryStore opens the store in dir, loading existing observations
+// within retention
+func newHistoryStore(dir string, retention time.Duration) (*HistoryStore, error) {
+	h := &HistoryStore{dir: dir, retention: retention, series: make(map[string][]Observation)}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		location := strings.TrimSuffix(filepath.Base(file), ".ndjson")
+		observations, err := readNDJSONFile[Observation](file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		sort.Slice(observations, func(i, j int) bool {
+			return observations[i].Time.Before(observations[j].Time)
+		})
+		h.series[location] = observations
+		if err := h.pruneLocked(location, time.Now()); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return h, nil
+}
+
+// Record stores the realtime part of resp, skipping reports that were
+// already recorded
+func (h *HistoryStore) Record(location string, resp *CaiyunAPIResponse) {
+	times := newCaiyunTimes(resp)
+	observedAt, ok := times.unix("server_time", resp.ServerTime)
+	if !ok {
+		log.Printf("Not recording observation for %s: %s", location, times.warnings[0])
+		return
+	}
+	rt := resp.Result.Realtime
+	obs := Observation{
+		Time:                observedAt,
+		Temperature:         rt.Temperature,
+		ApparentTemperature: rt.ApparentTemperature,
+		Humidity:            rt.Humidity,
+		Pressure:            rt.Pressure,
+		Visibility:          rt.Visibility,
+		Cloudrate:           rt.Cloudrate,
+		Dswrf:               rt.Dswrf,
+		WindSpeed:           rt.Wind.Speed,
+		WindDirection:       rt.Wind.Direction,
+		Precipitation:       rt.Precipitation.Local.Intensity,
+		AQI:                 rt.AirQuality.AQI.CHN,
+		PM25:                rt.AirQuality.PM25,
+		Condition:           rt.Skycon,
+	}
+	if err := h.Append(location, obs); err != nil {
+		log.Printf("Failed to record observation for %s: %v", location, err)
+	}
+}
+
+// Append adds an observation. Observations not newer than the latest
+// recorded one are ignored.
+func (h *HistoryStore) Append(location string, obs Observation) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series := h.series[location]
+	if n := len(series); n > 0 && !obs.Time.After(series[n-1].Time) {
+		return nil
+	}
+
+	if err := appendNDJSONFile(h.path(location), obs); err != nil {
+		return err
+	}
+
+	series = append(series, obs)
+	h.series[location] = series
+	if h.retention > 0 && obs.Time.Sub(series[0].Time) > h.retention+historyCompactSlack {
+		if err := h.pruneLocked(location, obs.Time); err != nil {
+			log.Printf("Failed to compact history for %s: %v", location, err)
+		}
+	}
+	return nil
+}
+
+// pruneLocked drops the observations of location older than the retention
+// before now and rewrites its file. Callers must hold h.mu.
+func (h *HistoryStore) pruneLocked(location string, now time.Time) error {
+	if h.retention <= 0 {
+		return nil
+	}
+	series := h.series[location]
+	cutoff := now.Add(-h.retention)
+	keep := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(cutoff) })
+	if keep == 0 {
+		return nil
+	}
+	// Copy so that the dropped observations can be freed
+	kept := slices.Clone(series[keep:])
+	if err := writeNDJSONFile(h.path(location), kept); err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		delete(h.series, location)
+	} else {
+		h.series[location] = kept
+	}
+	log.Printf("Dropped %d observations before %s from the history of %s", keep, cutoff.Format(time.RFC3339), location)
+	return nil
+}
+
+// Query returns the observations in [from, to)
+func (h *HistoryStore) Query(location string, from, to time.Time) []Observation {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	series := h.series[location]
+	start := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(from) })
+	end := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(to) })
+	return append([]Observation(nil), series[start:end]...)
+}
+
+// Zone returns the timezone of the location's observations
+func (h *HistoryStore) Zone(location string) *time.Location {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if series := h.series[location]; len(series) > 0 {
+		return series[len(series)-1].Time.Location()
+	}
+	return defaultZone
+}
+
+func (h *HistoryStore) path(location string) string {
+	return filepath.Join(h.dir, location+".ndjson")
+}
+
+// aggregateObservations groups observations into buckets of interval,
+// aligned to local midnight of the observations' timezone. For precipitation
+// the sum is the accumulated rainfall in mm rather than a sum of intensities.
+func aggregateObservations(observations []Observation, interval time.Duration, fields, aggregates []string) []HistoryBucket {
+	buckets := []HistoryBucket{}
+	if len(observations) == 0 {
+		return buckets
+	}
+
+	amounts := precipitationAmounts(observations)
+	_, offset := observations[0].Time.Zone()
+	seconds := int64(interval / time.Second)
+	zone := observations[0].Time.Location()
+
+	for start := 0; start < len(observations); {
+		key := floorDiv(observations[start].Time.Unix()+int64(offset), seconds)
+		end := start
+		for end < len(observations) && floorDiv(observations[end].Time.Unix()+int64(offset), seconds) == key {
+			end++
+		}
+
+		bucketStart := time.Unix(key*seconds-int64(offset), 0).In(zone)
+		bucket := HistoryBucket{
+			Start:  bucketStart,
+			End:    bucketStart.Add(interval),
+			Count:  end - start,
+			Fields: make(map[string]map[string]float64),
+		}
+		for _, field := range historyFields {
+			if !slices.Contains(fields, field.name) {
+				continue
+			}
+			values := make([]float64, 0, end-start)
+			for _, obs := range observations[start:end] {
+				values = append(values, field.value(obs))
+			}
+			var sumOverride []float64
+			if field.name == "precipitation" {
+				sumOverride = amounts[start:end]
+			}
+			bucket.Fields[field.name] = aggregateValues(values, sumOverride, aggregates)
+		}
+		buckets = append(buckets, bucket)
+		start = end
+	}
+	return buckets
+}
+
+func aggregateValues(values, sumValues []float64, aggregates []string) map[string]float64 {
+	minV, maxV, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, v := range values {
+		minV = math.Min(minV, v)
+		maxV = math.Max(maxV, v)
+		sum += v
+	}
+	avg := sum / float64(len(values))
+	if sumValues != nil {
+		sum = 0
+		for _, v := range sumValues {
+			sum += v
+		}
+	}
+
+	result := make(map[string]float64)
+	for _, agg := range aggregates {
+		switch agg {
+		case "min":
+			result[agg] = minV
+		case "max":
+			result[agg] = maxV
+		case "avg":
+			result[agg] = roundTo(avg, 2)
+		case "sum":
+			result[agg] = roundTo(sum, 2)
+		}
+	}
+	return result
+}
+
+// precipitationAmounts converts each intensity sample to the rainfall in mm
+// it represents, assuming it lasts until the next sample
+func precipitationAmounts(observations []Observation) []float64 {
+	amounts := make([]float64, len(observations))
+	for i, obs := range observations {
+		gap := maxPrecipitationGap
+		if i+1 < len(observations) {
+			gap = observations[i+1].Time.Sub(obs.Time)
+		} else if i > 0 {
+			gap = obs.Time.Sub(observations[i-1].Time)
+		}
+		if gap > maxPrecipitationGap {
+			gap = maxPrecipitationGap
+		}
+		amounts[i] = obs.Precipitation * gap.Hours()
+	}
+	return amounts
+}
+
+// parseInterval accepts Go durations such as "30m" or "6h" plus day and week
+// suffixes such as "1d" and "1w"
+func parseInterval(raw string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(raw, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(raw, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(strings.TrimRight(raw, "dw"))
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid interval %q", raw)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("invalid interval %q: expected e.g. 30m, 1h or 1d", raw)
+	}
+	return d, nil
+}
+
+// parseTimeParam accepts RFC3339 timestamps and plain dates, which are
+// interpreted in zone
+func parseTimeParam(raw string, zone *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, zone); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected RFC3339 or YYYY-MM-DD", raw)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryRetention(t *testing.T) {
+	dir := t.TempDir()
+	retention := 30 * 24 * time.Hour
+	start := time.Now().Add(-40*24*time.Hour + time.Hour)
+
+	store, err := newHistoryStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for day := range 40 {
+		if err := store.Append("loc", Observation{Time: start.Add(time.Duration(day) * 24 * time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(store.Query("loc", time.Time{}, time.Now())); n != 40 {
+		t.Fatalf("kept %d observations without a retention, want 40", n)
+	}
+
+	// Reopening with a retention drops old observations from memory and disk
+	store, err = newHistoryStore(dir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := store.Query("loc", time.Time{}, time.Now())
+	if len(kept) != 30 || kept[0].Time.Before(time.Now().Add(-retention)) {
+		t.Fatalf("kept %d observations from %v, want 30 within the retention", len(kept), kept[0].Time)
+	}
+	onDisk, err := readNDJSONFile[Observation](filepath.Join(dir, "loc.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) != 30 {
+		t.Errorf("file has %d observations after compaction, want 30", len(onDisk))
+	}
+
+	// Appending compacts once the oldest observation is a day past the
+	// retention
+	next := kept[len(kept)-1].Time
+	for range 3 {
+		next = next.Add(24 * time.Hour)
+		if err := store.Append("loc", Observation{Time: next}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept = store.Query("loc", time.Time{}, next.Add(time.Hour))
+	if oldest := kept[0].Time; next.Sub(oldest) > retention+historyCompactSlack {
+		t.Errorf("oldest observation %v is more than the retention before %v", oldest, next)
+	}
+	onDisk, err = readNDJSONFile[Observation](filepath.Join(dir, "loc.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) != len(kept) {
+		t.Errorf("file has %d observations, memory %d", len(onDisk), len(kept))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Lang identifies the language used for human readable text
+type Lang string
+
+const (
+	LangZH Lang = "zh"
+	LangEN Lang = "en"
+)
+
+// parseLang maps a lang query value such as "en", "en_US" or "zh-CN" to a
+// supported language, defaulting to Chinese
+func parseLang(s string) Lang {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.HasPrefix(s, "en") {
+		return LangEN
+	}
+	return LangZH
+}
+
+// localized holds the same text in every supported language
+type localized struct {
+	zh string
+	en string
+}
+
+func (l localized) in(lang Lang) string {
+	if lang == LangEN && l.en != "" {
+		return l.en
+	}
+	return l.zh
+}
+
+// UnmarshalJSON reads {"zh": "...", "en": "..."} or a plain string used for
+// every language
+func (l *localized) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*l = localized{zh: text}
+		return nil
+	}
+	var texts struct {
+		ZH string `json:"zh"`
+		EN string `json:"en"`
+	}
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return err
+	}
+	*l = localized{zh: texts.ZH, en: texts.EN}
+	return nil
+}
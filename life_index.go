@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// LifeIndex is one Caiyun life index with its numeric value kept
+type LifeIndex struct {
+	Index       float64 `json:"index"`
+	Level       string  `json:"level"`       // stable slug, e.g. "very_high"
+	Description string  `json:"description"` // e.g. "很强"
+	Advice      string  `json:"advice"`
+}
+
+// LifeIndices holds the life indices Caiyun reports. Realtime data only
+// carries ultraviolet and comfort; the others are nil there.
+type LifeIndices struct {
+	Ultraviolet *LifeIndex `json:"ultraviolet,omitempty"`
+	CarWashing  *LifeIndex `json:"car_washing,omitempty"`
+	Dressing    *LifeIndex `json:"dressing,omitempty"`
+	Comfort     *LifeIndex `json:"comfort,omitempty"`
+	ColdRisk    *LifeIndex `json:"cold_risk,omitempty"`
+}
+
+// lifeIndexLevel describes one step of a life index scale
+type lifeIndexLevel struct {
+	slug        string
+	description localized
+	advice      localized
+}
+
+// Scales by Caiyun index value. Daily ultraviolet is a 1-5 category while
+// realtime ultraviolet is the UV index itself, see realtimeUVLevel.
+var (
+	ultravioletLevels = map[int]lifeIndexLevel{
+		1: {"lowest", localized{"最弱", "Lowest"}, localized{"无需防护", "No protection needed"}},
+		2: {"low", localized{"弱", "Low"}, localized{"外出可涂防晒霜", "Sunscreen optional outdoors"}},
+		3: {"moderate", localized{"中等", "Moderate"}, localized{"外出请涂防晒霜，戴帽子", "Wear sunscreen and a hat outdoors"}},
+		4: {"high", localized{"强", "High"}, localized{"避免正午外出，注意防晒", "Avoid the midday sun and wear sunscreen"}},
+		5: {"very_high", localized{"很强", "Very high"}, localized{"尽量减少外出，做好全面防护", "Stay indoors where possible, protect all exposed skin"}},
+	}
+	carWashingLevels = map[int]lifeIndexLevel{
+		1: {"suitable", localized{"适宜", "Suitable"}, localized{"天气较好，适合洗车", "Good weather for washing the car"}},
+		2: {"fairly_suitable", localized{"较适宜", "Fairly suitable"}, localized{"可以洗车", "Fine for washing the car"}},
+		3: {"fairly_unsuitable", localized{"较不适宜", "Fairly unsuitable"}, localized{"近期可能降水或起风，不太适合洗车", "Rain or wind likely soon, better wait"}},
+		4: {"unsuitable", localized{"不适宜", "Unsuitable"}, localized{"不宜洗车", "Do not wash the car"}},
+	}
+	dressingLevels = map[int]lifeIndexLevel{
+		0: {"extremely_hot", localized{"极热", "Extremely hot"}, localized{"穿轻薄短袖短裤", "Wear the lightest clothes"}},
+		1: {"extremely_hot", localized{"极热", "Extremely hot"}, localized{"穿轻薄短袖短裤", "Wear the lightest clothes"}},
+		2: {"very_hot", localized{"很热", "Very hot"}, localized{"短袖短裤为宜", "Short sleeves and shorts"}},
+		3: {"hot", localized{"热", "Hot"}, localized{"短袖为宜", "Short sleeves"}},
+		4: {"warm", localized{"温暖", "Warm"}, localized{"单层长袖或薄外套", "Long sleeves or a thin jacket"}},
+		5: {"cool", localized{"凉爽", "Cool"}, localized{"夹克、风衣或薄毛衣", "Jacket or light sweater"}},
+		6: {"cold", localized{"冷", "Cold"}, localized{"大衣、毛衣加长裤", "Coat, sweater and trousers"}},
+		7: {"very_cold", localized{"寒冷", "Very cold"}, localized{"棉衣或羽绒服", "Padded or down jacket"}},
+		8: {"extremely_cold", localized{"极冷", "Extremely cold"}, localized{"厚羽绒服，戴帽子手套", "Heavy down coat, hat and gloves"}},
+	}
+	comfortLevels = map[int]lifeIndexLevel{
+		0:  {"muggy", localized{"闷热", "Muggy"}, localized{"注意防暑降温", "Keep cool and hydrated"}},
+		1:  {"scorching", localized{"酷热", "Scorching"}, localized{"避免高温时段外出", "Avoid going out in the heat"}},
+		2:  {"very_hot", localized{"很热", "Very hot"}, localized{"注意防暑", "Watch out for heat"}},
+		3:  {"hot", localized{"热", "Hot"}, localized{"适当减少户外活动", "Limit outdoor activity"}},
+		4:  {"warm", localized{"温暖", "Warm"}, localized{"较为舒适", "Fairly comfortable"}},
+		5:  {"comfortable", localized{"舒适", "Comfortable"}, localized{"天气舒适，适合户外活动", "Pleasant for outdoor activities"}},
+		6:  {"cool", localized{"凉爽", "Cool"}, localized{"体感凉爽，适当添衣", "Cool, add a layer"}},
+		7:  {"cold", localized{"冷", "Cold"}, localized{"注意保暖", "Keep warm"}},
+		8:  {"very_cold", localized{"很冷", "Very cold"}, localized{"注意防寒保暖", "Dress warmly"}},
+		9:  {"frigid", localized{"寒冷", "Frigid"}, localized{"减少户外停留", "Limit time outdoors"}},
+		10: {"extremely_cold", localized{"极冷", "Extremely cold"}, localized{"避免长时间户外活动", "Avoid long stays outdoors"}},
+		11: {"biting_cold", localized{"刺骨的冷", "Biting cold"}, localized{"尽量待在室内", "Stay indoors where possible"}},
+		12: {"damp_cold", localized{"湿冷", "Damp and cold"}, localized{"湿冷天气，注意防寒防潮", "Damp cold, keep dry and warm"}},
+		13: {"dry_cold", localized{"干冷", "Dry and cold"}, localized{"干冷天气，注意保湿保暖", "Dry cold, moisturize and keep warm"}},
+	}
+	coldRiskLevels = map[int]lifeIndexLevel{
+		1: {"low", localized{"少发", "Low"}, localized{"感冒机率较低", "Low chance of catching a cold"}},
+		2: {"moderate", localized{"较易发", "Moderate"}, localized{"注意增减衣物", "Dress for the temperature swings"}},
+		3: {"high", localized{"易发", "High"}, localized{"注意防寒保暖，预防感冒", "Keep warm to avoid catching a cold"}},
+		4: {"very_high", localized{"极易发", "Very high"}, localized{"感冒极易发生，体弱者减少外出", "Colds very likely, vulnerable people should stay in"}},
+	}
+)
+
+// realtimeUVLevel maps a UV index to the daily ultraviolet scale
+func realtimeUVLevel(uvi float64) int {
+	switch {
+	case uvi < 3:
+		return 1
+	case uvi < 5:
+		return 2
+	case uvi < 7:
+		return 3
+	case uvi < 10:
+		return 4
+	}
+	return 5
+}
+
+// newLifeIndex looks up index in levels. The provider description is kept
+// when the index is outside the known scale.
+func newLifeIndex(index float64, levels map[int]lifeIndexLevel, level int, desc string, lang Lang) *LifeIndex {
+	l, ok := levels[level]
+	if !ok {
+		return &LifeIndex{Index: index, Level: "unknown", Description: desc}
+	}
+	return &LifeIndex{
+		Index:       index,
+		Level:       l.slug,
+		Description: l.description.in(lang),
+		Advice:      l.advice.in(lang),
+	}
+}
+
+func convertLifeIndices(indices map[string]LifeIndexValueType, lang Lang) LifeIndices {
+	var result LifeIndices
+	if v, ok := indices["ultraviolet"]; ok {
+		result.Ultraviolet = newLifeIndex(v.Index, ultravioletLevels, realtimeUVLevel(v.Index), v.Desc, lang)
+	}
+	if v, ok := indices["comfort"]; ok {
+		result.Comfort = newLifeIndex(v.Index, comfortLevels, int(math.Round(v.Index)), v.Desc, lang)
+	}
+	return result
+}
+
+func convertDailyLifeIndices(indices map[string][]LifeIndexDailyValueType, dayIndex int, lang Lang) LifeIndices {
+	daily := func(name string, levels map[int]lifeIndexLevel) *LifeIndex {
+		values := indices[name]
+		if dayIndex >= len(values) {
+			return nil
+		}
+		index, err := strconv.ParseFloat(values[dayIndex].Index, 64)
+		if err != nil {
+			return &LifeIndex{Level: "unknown", Description: values[dayIndex].Desc}
+		}
+		return newLifeIndex(index, levels, int(math.Round(index)), values[dayIndex].Desc, lang)
+	}
+	return LifeIndices{
+		Ultraviolet: daily("ultraviolet", ultravioletLevels),
+		CarWashing:  daily("carWashing", carWashingLevels),
+		Dressing:    daily("dressing", dressingLevels),
+		Comfort:     daily("comfort", comfortLevels),
+		ColdRisk:    daily("coldRisk", coldRiskLevels),
+	}
+}
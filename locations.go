@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseNamedLocations reads a list like
+// "main-campus=116.3062,39.9841;east-gate=116.3101,39.9855"
+func parseNamedLocations(raw string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, geopos, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(id) == "" {
+			return nil, fmt.Errorf("invalid location entry %q, expected id=longitude,latitude", entry)
+		}
+		location, err := normalizeGeopos(geopos)
+		if err != nil {
+			return nil, fmt.Errorf("location %s: %w", id, err)
+		}
+		result[strings.TrimSpace(id)] = location
+	}
+	return result, nil
+}
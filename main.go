@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -8,11 +13,46 @@ import (
 func main() {
 	godotenv.Load()
 
+	cfg, args, err := loadConfig(os.Args[1:])
+	importing := len(args) > 0 && args[0] == "import-climate"
+	if err == nil {
+		err = cfg.Validate(importing)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	if importing {
+		importFlags := flag.NewFlagSet("import-climate", flag.ExitOnError)
+		location := importFlags.String("location", "", "location ID or geopos the data belongs to")
+		importFlags.Parse(args[1:])
+		if err := runClimateImport(cfg, *location, importFlags.Args()); err != nil {
+			log.Fatalf("Climate import failed: %v", err)
+		}
+		return
+	}
+
+	server, err := newServer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	go server.cache.Run(context.Background(), cfg.Cache.RefreshInterval.duration())
+
 	r := gin.Default()
 
 	r.SetTrustedProxies(nil)
 
-	setupRoutes(r)
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		r.Use(corsMiddleware(cfg.CORS.AllowedOrigins))
+	}
+	if cfg.RateLimit.RequestsPerMinute > 0 {
+		r.Use(newRateLimiter(cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.Burst).Middleware())
+	}
+
+	setupRoutes(r, server)
 
-	r.Run(":8080") // listen and serve on 0.0.0.0:8080
+	if err := r.Run(cfg.ListenAddr); err != nil {
+		log.Fatal(err)
+	}
 }
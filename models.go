@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // LifeIndexValueType represents a life index value with index and description
 type LifeIndexValueType struct {
@@ -233,6 +236,7 @@ type LightWeatherResponse struct {
 	Daily       []DailyWeather  `json:"daily"`
 	Summary     WeatherSummary  `json:"summary"`
 	LastUpdated time.Time       `json:"last_updated"`
+	Warnings    []string        `json:"warnings"` // timestamps that could not be converted
 }
 
 // LocationInfo represents location details
@@ -245,12 +249,21 @@ type LocationInfo struct {
 
 // WeatherAlert represents weather warnings and alerts
 type WeatherAlert struct {
-	Title       string    `json:"title"`
-	Level       string    `json:"level"` // e.g., "蓝色预警", "Ⅳ级/一般"
-	Description string    `json:"description"`
-	Location    string    `json:"location"`
-	PublishedAt time.Time `json:"published_at"`
-	Source      string    `json:"source"`
+	ID           string        `json:"id"`
+	Title        string        `json:"title"`
+	Code         string        `json:"code"`
+	Type         AlertType     `json:"type"`
+	TypeName     string        `json:"type_name"`
+	Color        AlertColor    `json:"color"`
+	ColorName    string        `json:"color_name"`
+	Severity     AlertSeverity `json:"severity"`
+	SeverityName string        `json:"severity_name"`
+	Level        string        `json:"level"` // e.g., "Ⅳ级/一般"
+	Description  string        `json:"description"`
+	Status       string        `json:"status"`
+	Location     string        `json:"location"`
+	PublishedAt  time.Time     `json:"published_at,omitzero"`
+	Source       string        `json:"source"`
 }
 
 // CurrentWeather represents current weather conditions
@@ -265,43 +278,64 @@ type CurrentWeather struct {
 	Visibility          float64           `json:"visibility"`
 	Precipitation       PrecipitationInfo `json:"precipitation"`
 	AirQuality          AirQualityInfo    `json:"air_quality"`
-	LifeIndices         map[string]string `json:"life_indices"` // index desc only
+	LifeIndices         LifeIndices       `json:"life_indices"`
+	TemperatureNormal   *float64          `json:"temperature_normal,omitempty"`
+	TemperatureAnomaly  *float64          `json:"temperature_anomaly,omitempty"` // vs normal at this time of day
+	IsDaytime           bool              `json:"is_daytime"`
+	Thermal             ThermalIndices    `json:"thermal"`
 }
 
 // HourlyWeather represents hourly forecast
 type HourlyWeather struct {
-	Time                time.Time `json:"time"`
-	Temperature         float64   `json:"temperature"`
-	ApparentTemperature float64   `json:"apparent_temperature"`
-	Condition           string    `json:"condition"`
-	PrecipitationMM     float64   `json:"precipitation_mm"`
-	PrecipitationProb   int       `json:"precipitation_probability"`
-	WindSpeed           float64   `json:"wind_speed"`
-	Humidity            float64   `json:"humidity"`
+	Time                time.Time      `json:"time"`
+	Temperature         float64        `json:"temperature"`
+	ApparentTemperature float64        `json:"apparent_temperature"`
+	Condition           string         `json:"condition"`
+	PrecipitationMM     float64        `json:"precipitation_mm"`
+	PrecipitationProb   int            `json:"precipitation_probability"`
+	WindSpeed           float64        `json:"wind_speed"` // deprecated, same as Wind.Speed
+	Wind                WindInfo       `json:"wind"`
+	Humidity            float64        `json:"humidity"`
+	IsDaytime           bool           `json:"is_daytime"`
+	Thermal             ThermalIndices `json:"thermal"`
 }
 
 // DailyWeather represents daily forecast
 type DailyWeather struct {
-	Date              time.Time         `json:"date"`
-	TemperatureMin    float64           `json:"temperature_min"`
-	TemperatureMax    float64           `json:"temperature_max"`
-	Condition         string            `json:"condition"`
-	ConditionDay      string            `json:"condition_day"`
-	ConditionNight    string            `json:"condition_night"`
-	PrecipitationMM   float64           `json:"precipitation_mm"`
-	PrecipitationProb int               `json:"precipitation_probability"`
-	Wind              WindInfo          `json:"wind"`
-	Sunrise           string            `json:"sunrise"`
-	Sunset            string            `json:"sunset"`
-	AirQuality        AirQualityInfo    `json:"air_quality"`
-	LifeIndices       map[string]string `json:"life_indices"`
-}
-
-// WindInfo represents wind information
-type WindInfo struct {
-	Speed     float64 `json:"speed"`
-	Direction float64 `json:"direction"`
-	Level     string  `json:"level"` // wind level description
+	Date                  time.Time       `json:"date"`
+	TemperatureMin        float64         `json:"temperature_min"`
+	TemperatureMax        float64         `json:"temperature_max"`
+	Condition             string          `json:"condition"`
+	ConditionDay          string          `json:"condition_day"`
+	ConditionNight        string          `json:"condition_night"`
+	PrecipitationMM       float64         `json:"precipitation_mm"` // peak intensity, mm/h
+	PrecipitationProb     int             `json:"precipitation_probability"`
+	Wind                  WindInfo        `json:"wind"` // strongest mean wind of the day
+	WindMin               WindInfo        `json:"wind_min"`
+	WindAvg               WindInfo        `json:"wind_avg"`
+	Sunrise               *time.Time      `json:"sunrise"` // nil during polar day or night
+	Sunset                *time.Time      `json:"sunset"`
+	AirQuality            AirQualityInfo  `json:"air_quality"`
+	LifeIndices           LifeIndices     `json:"life_indices"`
+	Normal                *DailyNormal    `json:"normal,omitempty"`
+	TemperatureMinAnomaly *float64        `json:"temperature_min_anomaly,omitempty"`
+	TemperatureMaxAnomaly *float64        `json:"temperature_max_anomaly,omitempty"`
+	Day                   *DayPartWeather `json:"day,omitempty"`   // 08:00-20:00
+	Night                 *DayPartWeather `json:"night,omitempty"` // 20:00-08:00 the next day
+}
+
+// DayPartWeather represents the forecast for the day or night part of a date
+type DayPartWeather struct {
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	TemperatureMin    float64   `json:"temperature_min"`
+	TemperatureMax    float64   `json:"temperature_max"`
+	TemperatureAvg    float64   `json:"temperature_avg"`
+	Condition         string    `json:"condition"`
+	ConditionText     string    `json:"condition_text"`
+	PrecipitationMM   float64   `json:"precipitation_mm"`
+	PrecipitationProb int       `json:"precipitation_probability"`
+	Wind              WindInfo  `json:"wind"`
 }
 
 // PrecipitationInfo represents precipitation details
@@ -326,14 +360,21 @@ type WeatherSummary struct {
 	Forecast string `json:"forecast"` // forecast keypoint
 }
 
-// ConvertToLightModel converts the full API response to a lightweight model
-func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
+// ConvertToLightModel converts the full API response to a lightweight model.
+// Wind and life index descriptions are given in lang.
+func ConvertToLightModel(full *CaiyunAPIResponse, lang Lang) *LightWeatherResponse {
 	if full == nil || full.Status != "ok" {
 		return nil
 	}
 
+	times := newCaiyunTimes(full)
+	suns := newSunSchedule(full, times)
+	lastUpdated, ok := times.unix("server_time", full.ServerTime)
+	if !ok {
+		lastUpdated = time.Now().In(times.zone)
+	}
 	light := &LightWeatherResponse{
-		LastUpdated: time.Unix(full.ServerTime, 0),
+		LastUpdated: lastUpdated,
 		Location: LocationInfo{
 			Coordinates: full.Location,
 			Timezone:    full.Timezone,
@@ -341,6 +382,7 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 		Summary: WeatherSummary{
 			Forecast: full.Result.ForecastKeypoint,
 		},
+		Warnings: []string{},
 	}
 
 	// Extract location info from alerts if available
@@ -351,16 +393,7 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 	}
 
 	// Convert alerts
-	for _, alert := range full.Result.Alert.Content {
-		light.Alerts = append(light.Alerts, WeatherAlert{
-			Title:       alert.Title,
-			Level:       extractAlertLevel(alert.Title),
-			Description: alert.Description,
-			Location:    alert.Location,
-			PublishedAt: time.Unix(alert.Pubtimestamp, 0),
-			Source:      alert.Source,
-		})
-	}
+	light.Alerts = convertAlertsIn(full, times)
 
 	// Convert current weather
 	rt := full.Result.Realtime
@@ -370,13 +403,9 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 		Condition:           rt.Skycon,
 		ConditionText:       translateSkycon(rt.Skycon),
 		Humidity:            rt.Humidity,
-		Wind: WindInfo{
-			Speed:     rt.Wind.Speed,
-			Direction: rt.Wind.Direction,
-			Level:     getWindLevel(rt.Wind.Speed),
-		},
-		Pressure:   rt.Pressure,
-		Visibility: rt.Visibility,
+		Wind:                newWindInfo(rt.Wind.Speed, rt.Wind.Direction, lang),
+		Pressure:            rt.Pressure,
+		Visibility:          rt.Visibility,
 		Precipitation: PrecipitationInfo{
 			Intensity: rt.Precipitation.Local.Intensity,
 			Status:    rt.Precipitation.Local.Status,
@@ -388,16 +417,27 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 			PM25:        int(rt.AirQuality.PM25),
 			PrimaryPoll: getPrimaryPollutant(rt.AirQuality),
 		},
-		LifeIndices: convertLifeIndices(rt.LifeIndex),
+		LifeIndices: convertLifeIndices(rt.LifeIndex, lang),
+		IsDaytime:   suns.isDaytime(light.LastUpdated),
+	}
+	// Without Caiyun's realtime UV index, approximate it as in hourlyUVIndex
+	uvIndex := rt.Dswrf / 100
+	if uv, ok := rt.LifeIndex["ultraviolet"]; ok {
+		uvIndex = uv.Index
 	}
+	light.Current.Thermal = newThermalIndices(rt.Temperature, rt.Humidity, rt.Wind.Speed, uvIndex)
 
-	// Convert hourly data (next 24 hours)
+	// Convert hourly data (next forecastHours hours)
 	hourly := full.Result.Hourly
 	light.Summary.Hourly = hourly.Description
+	uvIndices := hourlyUVIndex(full)
 
-	maxHours := min(24, len(hourly.Temperature))
+	maxHours := min(forecastHours, len(hourly.Temperature))
 	for i := 0; i < maxHours; i++ {
-		t, _ := time.Parse(time.RFC3339, hourly.Temperature[i].Datetime)
+		t, ok := times.parse(fmt.Sprintf("hourly.temperature[%d].datetime", i), hourly.Temperature[i].Datetime)
+		if !ok {
+			continue
+		}
 
 		precipProb := 0
 		precipMM := 0.0
@@ -407,8 +447,10 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 		}
 
 		windSpeed := 0.0
+		windDirection := 0.0
 		if i < len(hourly.Wind) {
 			windSpeed = hourly.Wind[i].Speed
+			windDirection = hourly.Wind[i].Direction
 		}
 
 		humidity := 0.0
@@ -426,6 +468,11 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 			condition = hourly.Skycon[i].Value
 		}
 
+		uvIndex := 0.0
+		if i < len(uvIndices) {
+			uvIndex = uvIndices[i]
+		}
+
 		light.Hourly = append(light.Hourly, HourlyWeather{
 			Time:                t,
 			Temperature:         hourly.Temperature[i].Value,
@@ -434,7 +481,10 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 			PrecipitationMM:     precipMM,
 			PrecipitationProb:   precipProb,
 			WindSpeed:           windSpeed,
+			Wind:                newWindInfo(windSpeed, windDirection, lang),
 			Humidity:            humidity,
+			IsDaytime:           suns.isDaytime(t),
+			Thermal:             newThermalIndices(hourly.Temperature[i].Value, humidity, windSpeed, uvIndex),
 		})
 	}
 
@@ -442,7 +492,10 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 	daily := full.Result.Daily
 	maxDays := min(7, len(daily.Temperature))
 	for i := 0; i < maxDays; i++ {
-		date, _ := time.Parse("2006-01-02T15:04-07:00", daily.Temperature[i].Date)
+		date, ok := times.parse(fmt.Sprintf("daily.temperature[%d].date", i), daily.Temperature[i].Date)
+		if !ok {
+			continue
+		}
 
 		precipProb := 0
 		precipMM := 0.0
@@ -451,20 +504,15 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 			precipMM = daily.Precipitation[i].Max
 		}
 
-		wind := WindInfo{}
+		var wind, windMin, windAvg WindInfo
 		if i < len(daily.Wind) {
-			wind = WindInfo{
-				Speed:     daily.Wind[i].Max.Speed,
-				Direction: daily.Wind[i].Max.Direction,
-				Level:     getWindLevel(daily.Wind[i].Max.Speed),
-			}
+			w := daily.Wind[i]
+			wind = newWindInfo(w.Max.Speed, w.Max.Direction, lang)
+			windMin = newWindInfo(w.Min.Speed, w.Min.Direction, lang)
+			windAvg = newWindInfo(w.Avg.Speed, w.Avg.Direction, lang)
 		}
 
-		sunrise, sunset := "", ""
-		if i < len(daily.Astro) {
-			sunrise = daily.Astro[i].Sunrise.Time
-			sunset = daily.Astro[i].Sunset.Time
-		}
+		sun, _ := suns.day(date)
 
 		condition := ""
 		conditionDay := ""
@@ -497,16 +545,80 @@ func ConvertToLightModel(full *CaiyunAPIResponse) *LightWeatherResponse {
 			PrecipitationMM:   precipMM,
 			PrecipitationProb: precipProb,
 			Wind:              wind,
-			Sunrise:           sunrise,
-			Sunset:            sunset,
+			WindMin:           windMin,
+			WindAvg:           windAvg,
+			Sunrise:           sun.sunrise,
+			Sunset:            sun.sunset,
 			AirQuality:        aqi,
-			LifeIndices:       convertDailyLifeIndices(daily.LifeIndex, i),
+			LifeIndices:       convertDailyLifeIndices(daily.LifeIndex, i, lang),
+			Day:               convertDayPart(date.Add(8*time.Hour), i, daily.Temperature08h20h, daily.Wind08h20h, daily.Precipitation08h20h, daily.Skycon08h20h, lang),
+			Night:             convertDayPart(date.Add(20*time.Hour), i, daily.Temperature20h32h, daily.Wind20h32h, daily.Precipitation20h32h, daily.Skycon20h32h, lang),
 		})
 	}
 
+	light.Warnings = append(light.Warnings, times.warnings...)
 	return light
 }
 
+// convertDayPart converts the i-th entry of a 12-hour series starting at
+// start, or returns nil when Caiyun did not provide it
+func convertDayPart(start time.Time, i int, temps []DailyRangeData, winds []DailyWindData, precips []DailyPrecipitationData, skycons []DailyStringIndex, lang Lang) *DayPartWeather {
+	if i >= len(temps) {
+		return nil
+	}
+	part := &DayPartWeather{
+		Start:          start,
+		End:            start.Add(12 * time.Hour),
+		TemperatureMin: temps[i].Min,
+		TemperatureMax: temps[i].Max,
+		TemperatureAvg: temps[i].Avg,
+	}
+	if i < len(skycons) {
+		part.Condition = skycons[i].Value
+		part.ConditionText = translateSkycon(skycons[i].Value)
+	}
+	if i < len(precips) {
+		part.PrecipitationMM = precips[i].Max
+		part.PrecipitationProb = precips[i].Probability
+	}
+	if i < len(winds) {
+		part.Wind = newWindInfo(winds[i].Max.Speed, winds[i].Max.Direction, lang)
+	}
+	return part
+}
+
+// convertAlerts converts the alerts currently in effect
+func convertAlerts(full *CaiyunAPIResponse) []WeatherAlert {
+	return convertAlertsIn(full, newCaiyunTimes(full))
+}
+
+// convertAlertsIn converts alerts using times for publication timestamps
+func convertAlertsIn(full *CaiyunAPIResponse, times *caiyunTimes) []WeatherAlert {
+	var alerts []WeatherAlert
+	for i, alert := range full.Result.Alert.Content {
+		class := classifyAlert(alert.Code, alert.Title)
+		publishedAt, _ := times.unix(fmt.Sprintf("alert.content[%d].pubtimestamp", i), alert.Pubtimestamp)
+		alerts = append(alerts, WeatherAlert{
+			ID:           alert.AlertID,
+			Title:        alert.Title,
+			Code:         alert.Code,
+			Type:         class.Type,
+			TypeName:     class.Type.Name(LangZH),
+			Color:        class.Color,
+			ColorName:    class.Color.Name(LangZH),
+			Severity:     class.Severity,
+			SeverityName: class.Severity.Name(LangZH),
+			Level:        class.Severity.Label(LangZH),
+			Description:  alert.Description,
+			Status:       alert.Status,
+			Location:     alert.Location,
+			PublishedAt:  publishedAt,
+			Source:       alert.Source,
+		})
+	}
+	return alerts
+}
+
 // Helper functions
 func min(a, b int) int {
 	if a < b {
@@ -515,68 +627,40 @@ func min(a, b int) int {
 	return b
 }
 
-func extractAlertLevel(title string) string {
-	// Extract alert level from title like "台风蓝色预警[Ⅳ级/一般]"
-	if len(title) > 0 {
-		// Simple extraction - could be improved with regex
-		if contains := func(s, substr string) bool {
-			return len(s) >= len(substr) && s[len(s)-len(substr):] == substr
-		}; contains(title, "]") {
-			start := -1
-			for i := len(title) - 1; i >= 0; i-- {
-				if title[i] == '[' {
-					start = i
-					break
-				}
-			}
-			if start != -1 {
-				return title[start+1 : len(title)-1]
-			}
-		}
-	}
-	return "一般"
+// skyconNames holds the localized text of every Caiyun skycon
+var skyconNames = map[string]localized{
+	"CLEAR_DAY":           {"晴天", "Clear"},
+	"CLEAR_NIGHT":         {"晴夜", "Clear"},
+	"PARTLY_CLOUDY_DAY":   {"多云", "Partly cloudy"},
+	"PARTLY_CLOUDY_NIGHT": {"多云", "Partly cloudy"},
+	"CLOUDY":              {"阴天", "Cloudy"},
+	"LIGHT_HAZE":          {"轻度雾霾", "Light haze"},
+	"MODERATE_HAZE":       {"中度雾霾", "Moderate haze"},
+	"HEAVY_HAZE":          {"重度雾霾", "Heavy haze"},
+	"LIGHT_RAIN":          {"小雨", "Light rain"},
+	"MODERATE_RAIN":       {"中雨", "Moderate rain"},
+	"HEAVY_RAIN":          {"大雨", "Heavy rain"},
+	"STORM_RAIN":          {"暴雨", "Rainstorm"},
+	"FOG":                 {"雾", "Fog"},
+	"LIGHT_SNOW":          {"小雪", "Light snow"},
+	"MODERATE_SNOW":       {"中雪", "Moderate snow"},
+	"HEAVY_SNOW":          {"大雪", "Heavy snow"},
+	"STORM_SNOW":          {"暴雪", "Snowstorm"},
+	"DUST":                {"浮尘", "Dust"},
+	"SAND":                {"沙尘", "Sand"},
+	"WIND":                {"大风", "Windy"},
 }
 
 func translateSkycon(skycon string) string {
-	translations := map[string]string{
-		"CLEAR_DAY":           "晴天",
-		"CLEAR_NIGHT":         "晴夜",
-		"PARTLY_CLOUDY_DAY":   "多云",
-		"PARTLY_CLOUDY_NIGHT": "多云",
-		"CLOUDY":              "阴天",
-		"LIGHT_RAIN":          "小雨",
-		"MODERATE_RAIN":       "中雨",
-		"HEAVY_RAIN":          "大雨",
-		"STORM_RAIN":          "暴雨",
-		"WIND":                "大风",
-	}
-	if text, ok := translations[skycon]; ok {
-		return text
-	}
-	return skycon
+	return skyconText(skycon, LangZH)
 }
 
-func getWindLevel(speed float64) string {
-	// Convert m/s to wind level
-	if speed < 0.3 {
-		return "无风"
-	} else if speed < 1.6 {
-		return "软风"
-	} else if speed < 3.4 {
-		return "轻风"
-	} else if speed < 5.5 {
-		return "微风"
-	} else if speed < 8.0 {
-		return "和风"
-	} else if speed < 10.8 {
-		return "清风"
-	} else if speed < 13.9 {
-		return "强风"
-	} else if speed < 17.2 {
-		return "疾风"
-	} else {
-		return "大风"
+// skyconText returns the skycon in lang, or the raw value when unknown
+func skyconText(skycon string, lang Lang) string {
+	if text, ok := skyconNames[skycon]; ok {
+		return text.in(lang)
 	}
+	return skycon
 }
 
 func getPrimaryPollutant(aq any) string {
@@ -585,34 +669,22 @@ func getPrimaryPollutant(aq any) string {
 }
 
 func getAQILevel(aqi int) string {
-	if aqi <= 50 {
-		return "优"
-	} else if aqi <= 100 {
-		return "良"
-	} else if aqi <= 150 {
-		return "轻度污染"
-	} else if aqi <= 200 {
-		return "中度污染"
-	} else if aqi <= 300 {
-		return "重度污染"
-	}
-	return "严重污染"
-}
-
-func convertLifeIndices(indices map[string]LifeIndexValueType) map[string]string {
-	result := make(map[string]string)
-	for key, value := range indices {
-		result[key] = value.Desc
-	}
-	return result
-}
-
-func convertDailyLifeIndices(indices map[string][]LifeIndexDailyValueType, dayIndex int) map[string]string {
-	result := make(map[string]string)
-	for key, values := range indices {
-		if dayIndex < len(values) {
-			result[key] = values[dayIndex].Desc
-		}
+	return aqiLevelText(aqi, LangZH)
+}
+
+// aqiLevelText returns the Chinese AQI category of aqi in lang
+func aqiLevelText(aqi int, lang Lang) string {
+	switch {
+	case aqi <= 50:
+		return localized{"优", "good"}.in(lang)
+	case aqi <= 100:
+		return localized{"良", "moderate"}.in(lang)
+	case aqi <= 150:
+		return localized{"轻度污染", "unhealthy for sensitive groups"}.in(lang)
+	case aqi <= 200:
+		return localized{"中度污染", "unhealthy"}.in(lang)
+	case aqi <= 300:
+		return localized{"重度污染", "very unhealthy"}.in(lang)
 	}
-	return result
+	return localized{"严重污染", "hazardous"}.in(lang)
 }
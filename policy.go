@@ -0,0 +1,379 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultPolicies is used when POLICY_FILE is not set. The file doubles as
+// a template for custom policies.
+//
+//go:embed config/policies.json
+var defaultPolicies []byte
+
+// PolicyAction is the outcome of a policy, ordered allow < warn < cancel
+type PolicyAction string
+
+const (
+	PolicyAllow  PolicyAction = "allow"
+	PolicyWarn   PolicyAction = "warn"
+	PolicyCancel PolicyAction = "cancel"
+)
+
+func (a PolicyAction) rank() int {
+	switch a {
+	case PolicyWarn:
+		return 1
+	case PolicyCancel:
+		return 2
+	}
+	return 0
+}
+
+// policyMetrics are the values rules can compare, per forecast hour. Units
+// follow the light model: °C, km/h, humidity from 0 to 1.
+var policyMetrics = map[string]bool{
+	"temperature":               true,
+	"apparent_temperature":      true,
+	"humidity":                  true,
+	"dew_point":                 true,
+	"heat_index":                true,
+	"wind_chill":                true,
+	"humidex":                   true,
+	"wbgt":                      true,
+	"uv_index":                  true,
+	"wind_speed":                true,
+	"gust":                      true,
+	"beaufort":                  true,
+	"gust_beaufort":             true,
+	"precipitation_mm":          true,
+	"precipitation_probability": true,
+	"aqi":                       true,
+}
+
+// PolicyRule triggers its action when any hour of the window is above or
+// below a threshold. The "alert" metric instead matches active alerts of
+// AlertType (any type when unset) and at least MinColor.
+type PolicyRule struct {
+	Metric    string       `json:"metric"`
+	Above     *float64     `json:"above,omitempty"`
+	Below     *float64     `json:"below,omitempty"`
+	AlertType AlertType    `json:"alert_type,omitempty"`
+	MinColor  AlertColor   `json:"min_color,omitempty"`
+	Action    PolicyAction `json:"action"`
+	Reason    localized    `json:"reason"`
+}
+
+// Policy is a named set of rules, e.g. for outdoor PE classes
+type Policy struct {
+	ID    string       `json:"id"`
+	Name  localized    `json:"name"`
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicySet holds the configured policies
+type PolicySet struct {
+	Policies []Policy `json:"policies"`
+}
+
+// loadPolicies reads policies from path, or the built-in policies when path
+// is empty
+func loadPolicies(path string) (*PolicySet, error) {
+	data := defaultPolicies
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var set PolicySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, policy := range set.Policies {
+		if policy.ID == "" {
+			return nil, fmt.Errorf("policy without id")
+		}
+		if seen[policy.ID] {
+			return nil, fmt.Errorf("duplicate policy %q", policy.ID)
+		}
+		seen[policy.ID] = true
+		for i, rule := range policy.Rules {
+			if err := rule.validate(); err != nil {
+				return nil, fmt.Errorf("policy %q rule %d: %w", policy.ID, i, err)
+			}
+		}
+	}
+	return &set, nil
+}
+
+func (rule PolicyRule) validate() error {
+	if rule.Action != PolicyWarn && rule.Action != PolicyCancel {
+		return fmt.Errorf("action must be warn or cancel, got %q", rule.Action)
+	}
+	if rule.Metric == "alert" {
+		return nil
+	}
+	if !policyMetrics[rule.Metric] {
+		return fmt.Errorf("unknown metric %q", rule.Metric)
+	}
+	if (rule.Above == nil) == (rule.Below == nil) {
+		return fmt.Errorf("exactly one of above and below is required")
+	}
+	return nil
+}
+
+// Select returns the policy with id, or every policy when id is empty
+func (set *PolicySet) Select(id string) ([]Policy, bool) {
+	if id == "" {
+		return set.Policies, true
+	}
+	for _, policy := range set.Policies {
+		if policy.ID == id {
+			return []Policy{policy}, true
+		}
+	}
+	return nil, false
+}
+
+// policySample is the weather from Time until Until; metrics that are not
+// known for the time, such as the heat index in cool weather, are missing
+type policySample struct {
+	Time   time.Time
+	Until  time.Time
+	Values map[string]float64
+}
+
+// policySamples collects the realtime conditions, if the window has begun,
+// and the forecast hours overlapping [start, end)
+func policySamples(ext *ExtendedWeatherResponse, start, end time.Time) []policySample {
+	var samples []policySample
+	now := time.Now()
+	if !now.Before(start) && now.Before(end) {
+		current := ext.Current
+		sample := newPolicySample(now, current.Temperature, current.ApparentTemperature, current.Humidity, current.Thermal, current.Wind)
+		sample.Until = now
+		sample.Values["precipitation_mm"] = current.Precipitation.Intensity
+		sample.Values["aqi"] = float64(current.AirQuality.AQI)
+		samples = append(samples, sample)
+	}
+	for _, hour := range ext.Hourly {
+		if !hour.Time.Add(time.Hour).After(start) || !hour.Time.Before(end) || !hour.Time.Add(time.Hour).After(now) {
+			continue
+		}
+		sample := newPolicySample(hour.Time, hour.Temperature, hour.ApparentTemperature, hour.Humidity, hour.Thermal, hour.Wind)
+		sample.Until = hour.Time.Add(time.Hour)
+		sample.Values["precipitation_mm"] = hour.PrecipitationMM
+		sample.Values["precipitation_probability"] = float64(hour.PrecipitationProb)
+		sample.Values["aqi"] = float64(hour.AQI)
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func newPolicySample(t time.Time, temperature, apparent, humidity float64, thermal ThermalIndices, wind WindInfo) policySample {
+	values := map[string]float64{
+		"temperature":          temperature,
+		"apparent_temperature": apparent,
+		"humidity":             humidity,
+		"dew_point":            thermal.DewPoint,
+		"humidex":              thermal.Humidex,
+		"wbgt":                 thermal.WBGT,
+		"uv_index":             thermal.UVIndex,
+		"wind_speed":           wind.Speed,
+		"gust":                 wind.Gust,
+		"beaufort":             float64(wind.Beaufort),
+		"gust_beaufort":        float64(wind.GustBeaufort),
+	}
+	if thermal.HeatIndex != nil {
+		values["heat_index"] = *thermal.HeatIndex
+	}
+	if thermal.WindChill != nil {
+		values["wind_chill"] = *thermal.WindChill
+	}
+	return policySample{Time: t, Values: values}
+}
+
+// PolicyEvaluation is the decision for a location and time window, the
+// strictest of the evaluated policies. When the forecast does not reach the
+// end of the window the decision is at least warn, since the rest of the
+// window was not checked.
+type PolicyEvaluation struct {
+	Location    string         `json:"location"`
+	Lang        Lang           `json:"lang"`
+	Start       time.Time      `json:"start"`
+	End         time.Time      `json:"end"`
+	CoveredFrom time.Time      `json:"covered_from"`
+	CoveredTo   time.Time      `json:"covered_to"`
+	Complete    bool           `json:"complete"` // the forecast covers the whole window
+	Decision    PolicyAction   `json:"decision"`
+	Reasons     []PolicyReason `json:"reasons,omitempty"`
+	Policies    []PolicyResult `json:"policies"`
+}
+
+// policyCoverageText explains an incomplete evaluation
+var policyCoverageText = localized{"预报仅覆盖至 %s，之后的时段未评估", "The forecast only covers the window until %s; the rest was not evaluated"}
+
+// PolicyResult is the decision of one policy with the rules that triggered
+type PolicyResult struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Decision PolicyAction   `json:"decision"`
+	Reasons  []PolicyReason `json:"reasons"`
+}
+
+// PolicyReason is a triggered rule. For threshold rules Value is the worst
+// value in the window and Time when it occurs.
+type PolicyReason struct {
+	Action    PolicyAction `json:"action"`
+	Metric    string       `json:"metric"`
+	Message   string       `json:"message"`
+	Value     *float64     `json:"value,omitempty"`
+	Threshold *float64     `json:"threshold,omitempty"`
+	Time      *time.Time   `json:"time,omitempty"`
+	AlertID   string       `json:"alert_id,omitempty"`
+}
+
+// evaluatePolicies applies policies to the samples of a window and the
+// alerts currently in effect. Lifted alerts are ignored.
+func evaluatePolicies(location string, policies []Policy, samples []policySample, alerts []WeatherAlert, start, end time.Time, lang Lang) (*PolicyEvaluation, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no forecast covers %s to %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	var active []WeatherAlert
+	for _, alert := range alerts {
+		if !alertCancelled(alert) {
+			active = append(active, alert)
+		}
+	}
+	alerts = active
+
+	evaluation := &PolicyEvaluation{
+		Location:    location,
+		Lang:        lang,
+		Start:       start,
+		End:         end,
+		CoveredFrom: samples[0].Time,
+		CoveredTo:   samples[0].Until,
+		Decision:    PolicyAllow,
+		Policies:    []PolicyResult{},
+	}
+	for _, sample := range samples {
+		if sample.Time.Before(evaluation.CoveredFrom) {
+			evaluation.CoveredFrom = sample.Time
+		}
+		if sample.Until.After(evaluation.CoveredTo) {
+			evaluation.CoveredTo = sample.Until
+		}
+	}
+	if evaluation.CoveredFrom.Before(start) {
+		evaluation.CoveredFrom = start
+	}
+	if evaluation.CoveredTo.After(end) {
+		evaluation.CoveredTo = end
+	}
+	// The part of the window that is already over needs no forecast
+	needFrom := start
+	if now := time.Now(); now.After(needFrom) {
+		needFrom = now
+	}
+	evaluation.Complete = !evaluation.CoveredFrom.After(needFrom) && !evaluation.CoveredTo.Before(end)
+	if !evaluation.Complete {
+		evaluation.Decision = PolicyWarn
+		evaluation.Reasons = append(evaluation.Reasons, PolicyReason{
+			Action:  PolicyWarn,
+			Metric:  "coverage",
+			Message: fmt.Sprintf(policyCoverageText.in(lang), evaluation.CoveredTo.Format(time.RFC3339)),
+		})
+	}
+	for _, policy := range policies {
+		result := PolicyResult{
+			ID:       policy.ID,
+			Name:     policy.Name.in(lang),
+			Decision: PolicyAllow,
+			Reasons:  []PolicyReason{},
+		}
+		for _, rule := range policy.Rules {
+			for _, reason := range rule.evaluate(samples, alerts, lang) {
+				result.Reasons = append(result.Reasons, reason)
+				if reason.Action.rank() > result.Decision.rank() {
+					result.Decision = reason.Action
+				}
+			}
+		}
+		if result.Decision.rank() > evaluation.Decision.rank() {
+			evaluation.Decision = result.Decision
+		}
+		evaluation.Policies = append(evaluation.Policies, result)
+	}
+	return evaluation, nil
+}
+
+// evaluate returns one reason per matching alert, or one for the worst
+// sample crossing the threshold
+func (rule PolicyRule) evaluate(samples []policySample, alerts []WeatherAlert, lang Lang) []PolicyReason {
+	if rule.Metric == "alert" {
+		var reasons []PolicyReason
+		for _, alert := range alerts {
+			if rule.AlertType != AlertTypeUnknown && alert.Type != rule.AlertType {
+				continue
+			}
+			if alert.Color < rule.MinColor {
+				continue
+			}
+			class := AlertClass{Type: alert.Type, Color: alert.Color, Severity: alert.Severity}
+			message := localizedAlertTitle(alert.Title, "", class, lang)
+			if reason := rule.Reason.in(lang); reason != "" {
+				message = reason + ": " + message
+			}
+			reasons = append(reasons, PolicyReason{
+				Action:  rule.Action,
+				Metric:  rule.Metric,
+				Message: message,
+				AlertID: alert.ID,
+			})
+		}
+		return reasons
+	}
+
+	threshold, op := rule.Above, ">"
+	if rule.Below != nil {
+		threshold, op = rule.Below, "<"
+	}
+	var worst *policySample
+	var worstValue float64
+	for i, sample := range samples {
+		value, ok := sample.Values[rule.Metric]
+		if !ok {
+			continue
+		}
+		if rule.Above != nil && value > *threshold && (worst == nil || value > worstValue) ||
+			rule.Below != nil && value < *threshold && (worst == nil || value < worstValue) {
+			worst, worstValue = &samples[i], value
+		}
+	}
+	if worst == nil {
+		return nil
+	}
+
+	message := rule.Reason.in(lang)
+	if message == "" {
+		message = rule.Metric + " " + strconv.FormatFloat(worstValue, 'f', -1, 64) + " " + op + " " + strconv.FormatFloat(*threshold, 'f', -1, 64)
+	}
+	t := worst.Time
+	return []PolicyReason{{
+		Action:    rule.Action,
+		Metric:    rule.Metric,
+		Message:   message,
+		Value:     &worstValue,
+		Threshold: threshold,
+		Time:      &t,
+	}}
+}
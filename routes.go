@@ -4,7 +4,29 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func setupRoutes(r *gin.Engine) {
+func setupRoutes(r *gin.Engine, s *Server) {
 	r.GET("/", HelloHandler)
-	r.GET("/api/weather", GetWeatherHandler)
+	r.GET("/api/weather", s.GetWeatherHandler)
+	r.GET("/api/weather/hourly", s.GetHourlyWeatherHandler)
+	r.GET("/api/weather/daily", s.GetDailyWeatherHandler)
+	r.GET("/api/weather/calendar.ics", s.GetWeatherCalendarHandler)
+	r.GET("/api/weather/briefing", s.GetWeatherBriefingHandler)
+	r.GET("/api/weather/advice", s.GetWeatherAdviceHandler)
+	r.GET("/api/weather/window", s.GetWeatherWindowHandler)
+	r.GET("/api/weather/history", s.GetWeatherHistoryHandler)
+	r.GET("/api/weather/verification", s.GetVerificationHandler)
+	r.GET("/api/weather/stream", s.StreamWeatherHandler)
+	r.GET("/api/weather/ws", s.WeatherSocketHandler)
+	r.GET("/api/astro", s.GetAstroHandler)
+	r.GET("/api/solar", s.GetSolarHandler)
+	r.GET("/api/policy/evaluate", s.GetPolicyEvaluationHandler)
+	r.GET("/api/alerts/feed.atom", s.GetAlertFeedHandler("atom"))
+	r.GET("/api/alerts/feed.rss", s.GetAlertFeedHandler("rss"))
+	r.GET("/api/alerts/changes", s.GetAlertChangesHandler)
+
+	r.POST("/api/subscriptions", s.CreateSubscriptionHandler)
+	r.GET("/api/subscriptions", s.ListSubscriptionsHandler)
+	r.GET("/api/subscriptions/:id", s.GetSubscriptionHandler)
+	r.DELETE("/api/subscriptions/:id", s.DeleteSubscriptionHandler)
+	r.GET("/api/subscriptions/:id/deliveries", s.GetSubscriptionDeliveriesHandler)
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Server holds the configuration and services shared by the HTTP handlers
+type Server struct {
+	config        *Config
+	cache         *WeatherCache
+	alerts        *AlertTracker
+	subscriptions *SubscriptionStore
+	hub           *WeatherHub
+	history       *HistoryStore
+	forecasts     *ForecastStore
+	climate       *ClimateStore
+	briefings     *BriefingTemplates
+	advice        *AdviceRules
+	policies      *PolicySet
+}
+
+// newServer opens the stores under the data directory, loads the rule files
+// and connects everything to the weather cache
+func newServer(cfg *Config) (*Server, error) {
+	s := &Server{config: cfg}
+	var err error
+
+	s.cache = newWeatherCache(providerFetcher(cfg.Providers), cfg.Cache.TTL.duration())
+
+	s.history, err = newHistoryStore(filepath.Join(cfg.DataDir, "history"), cfg.History.Retention.duration())
+	if err != nil {
+		return nil, fmt.Errorf("open history store: %w", err)
+	}
+	s.climate, err = newClimateStore(filepath.Join(cfg.DataDir, "climate"), s.history)
+	if err != nil {
+		return nil, fmt.Errorf("open climate store: %w", err)
+	}
+
+	s.hub = newWeatherHub(cfg.Stream.MaxPerClient, cfg.Stream.MaxTotal, s.climate)
+	s.cache.OnUpdate(s.hub.PublishWeather)
+
+	s.alerts, err = newAlertTracker(filepath.Join(cfg.DataDir, "alerts.json"))
+	if err != nil {
+		return nil, fmt.Errorf("load alert state: %w", err)
+	}
+	s.cache.OnUpdate(func(location string, resp *CaiyunAPIResponse) {
+		s.hub.PublishAlerts(location, s.alerts.Observe(location, convertAlerts(resp)))
+	})
+
+	s.cache.OnUpdate(s.history.Record)
+
+	s.forecasts, err = newForecastStore(filepath.Join(cfg.DataDir, "forecasts"))
+	if err != nil {
+		return nil, fmt.Errorf("open forecast store: %w", err)
+	}
+	s.cache.OnUpdate(s.forecasts.Record)
+
+	s.subscriptions, err = newSubscriptionStore(filepath.Join(cfg.DataDir, "subscriptions.json"))
+	if err != nil {
+		return nil, fmt.Errorf("load subscriptions: %w", err)
+	}
+	for _, location := range s.subscriptions.Locations() {
+		s.cache.Watch(location)
+	}
+	s.cache.OnUpdate(s.subscriptions.Evaluate)
+	s.subscriptions.Resume()
+
+	s.advice, err = loadAdviceRules(cfg.AdviceRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("load advice rules: %w", err)
+	}
+
+	s.briefings, err = newBriefingTemplates(cfg.BriefingTemplateDir, s.advice.Umbrella)
+	if err != nil {
+		return nil, fmt.Errorf("load briefing templates: %w", err)
+	}
+
+	s.policies, err = loadPolicies(cfg.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load policies: %w", err)
+	}
+	return s, nil
+}
+
+// providerFetcher fetches from each provider in turn until one succeeds, so
+// that a second token can take over when the first runs out of quota
+func providerFetcher(providers []ProviderConfig) func(location string) (*CaiyunAPIResponse, error) {
+	return func(location string) (*CaiyunAPIResponse, error) {
+		var errs []error
+		for _, provider := range providers {
+			resp, err := fetchCaiyunWeather(provider.Token, location)
+			if err == nil {
+				return resp, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
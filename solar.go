@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	solarConstant = 1361.0 // W/m² at the mean Earth-Sun distance
+	groundAlbedo  = 0.2
+	// pvPerformanceRatio covers inverter, wiring, soiling and mismatch
+	// losses of a typical rooftop system
+	pvPerformanceRatio = 0.86
+	// pvTemperatureCoefficient is the power loss per °C above 25°C for
+	// crystalline silicon modules
+	pvTemperatureCoefficient = -0.004
+	// pvNOCT is the nominal operating cell temperature in °C
+	pvNOCT = 45.0
+	// minSunCosZenith ignores the direct component when the sun is within a
+	// few degrees of the horizon, where decomposition is unreliable
+	minSunCosZenith = 0.065
+)
+
+// PVSystem describes a rooftop installation
+type PVSystem struct {
+	CapacityKW float64 `json:"capacity_kw"`
+	Tilt       float64 `json:"tilt"`    // degrees from horizontal
+	Azimuth    float64 `json:"azimuth"` // degrees clockwise from north, 180 faces south
+}
+
+// Validate checks the system parameters
+func (s PVSystem) Validate() error {
+	if s.CapacityKW <= 0 {
+		return fmt.Errorf("capacity_kw must be positive")
+	}
+	if s.Tilt < 0 || s.Tilt > 90 {
+		return fmt.Errorf("tilt must be between 0 and 90 degrees")
+	}
+	if s.Azimuth < 0 || s.Azimuth >= 360 {
+		return fmt.Errorf("azimuth must be between 0 and 360 degrees")
+	}
+	return nil
+}
+
+// SolarHour is the estimated production of one forecast hour
+type SolarHour struct {
+	Time            time.Time `json:"time"`
+	SunElevation    float64   `json:"sun_elevation"`
+	SunAzimuth      float64   `json:"sun_azimuth"`
+	GHI             float64   `json:"ghi"` // global horizontal irradiance, W/m²
+	DNI             float64   `json:"dni"` // direct normal irradiance, W/m²
+	DHI             float64   `json:"dhi"` // diffuse horizontal irradiance, W/m²
+	POA             float64   `json:"poa"` // plane-of-array irradiance, W/m²
+	CellTemperature float64   `json:"cell_temperature"`
+	PowerKW         float64   `json:"power_kw"`
+	EnergyKWh       float64   `json:"energy_kwh"`
+}
+
+// SolarDay sums the hourly estimates of one local date
+type SolarDay struct {
+	Date        string  `json:"date"`
+	EnergyKWh   float64 `json:"energy_kwh"`
+	PeakPowerKW float64 `json:"peak_power_kw"`
+	Hours       int     `json:"hours"`  // forecast hours covered
+	Source      string  `json:"source"` // "hourly" or "daily" for days past the hourly forecast
+}
+
+// SolarForecast is the PV production estimate for a location
+type SolarForecast struct {
+	Location string      `json:"location"`
+	System   PVSystem    `json:"system"`
+	Hourly   []SolarHour `json:"hourly"`
+	Daily    []SolarDay  `json:"daily"`
+}
+
+// estimateSolar converts the hourly irradiance forecast into PV output.
+// Caiyun's dswrf is global horizontal irradiance; it is split into direct
+// and diffuse parts with the Erbs model and transposed onto the panel with
+// an isotropic sky. Each value is taken as the mean of the following hour.
+// Days past the hourly forecast are estimated from the daily mean dswrf on a
+// horizontal plane.
+func estimateSolar(location string, resp *CaiyunAPIResponse, system PVSystem) (*SolarForecast, error) {
+	if len(resp.Location) != 2 {
+		return nil, fmt.Errorf("forecast has no coordinates")
+	}
+	lat, lng := resp.Location[0], resp.Location[1]
+	times := newCaiyunTimes(resp)
+	hourly := resp.Result.Hourly
+
+	forecast := &SolarForecast{
+		Location: location,
+		System:   system,
+		Hourly:   []SolarHour{},
+		Daily:    []SolarDay{},
+	}
+	days := make(map[string]*SolarDay)
+	for i, point := range hourly.Dswrf {
+		t, ok := times.parse("hourly.dswrf.datetime", point.Datetime)
+		if !ok {
+			continue
+		}
+		airTemperature := 25.0
+		if i < len(hourly.Temperature) {
+			airTemperature = hourly.Temperature[i].Value
+		}
+
+		hour := solarHour(t, math.Max(point.Value, 0), airTemperature, lat, lng, system)
+		forecast.Hourly = append(forecast.Hourly, hour)
+
+		key := t.Format("2006-01-02")
+		day, ok := days[key]
+		if !ok {
+			forecast.Daily = append(forecast.Daily, SolarDay{Date: key, Source: "hourly"})
+			day = &forecast.Daily[len(forecast.Daily)-1]
+			days[key] = day
+		}
+		day.EnergyKWh += hour.EnergyKWh
+		day.PeakPowerKW = math.Max(day.PeakPowerKW, hour.PowerKW)
+		day.Hours++
+	}
+	for _, point := range resp.Result.Daily.Dswrf {
+		t, ok := times.parse("daily.dswrf.date", point.Date)
+		if !ok {
+			continue
+		}
+		key := t.Format("2006-01-02")
+		if _, ok := days[key]; ok {
+			continue
+		}
+		forecast.Daily = append(forecast.Daily, SolarDay{
+			Date:      key,
+			EnergyKWh: system.CapacityKW * math.Max(point.Avg, 0) * 24 / 1000 * pvPerformanceRatio,
+			Source:    "daily",
+		})
+	}
+	for i := range forecast.Daily {
+		forecast.Daily[i].EnergyKWh = roundTo(forecast.Daily[i].EnergyKWh, 2)
+	}
+	return forecast, nil
+}
+
+func solarHour(t time.Time, ghi, airTemperature, lat, lng float64, system PVSystem) SolarHour {
+	azimuth, elevation := sunPosition(t.Add(30*time.Minute), lat, lng)
+	hour := SolarHour{
+		Time:         t,
+		SunElevation: roundTo(elevation, 1),
+		SunAzimuth:   roundTo(azimuth, 1),
+		GHI:          roundTo(ghi, 1),
+	}
+
+	cosZenith := math.Sin(elevation * rad)
+	dni, dhi := 0.0, ghi
+	if cosZenith > minSunCosZenith && ghi > 0 {
+		extraterrestrial := solarConstant * (1 + 0.033*math.Cos(2*math.Pi*float64(t.YearDay())/365))
+		kt := math.Min(ghi/(extraterrestrial*cosZenith), 1)
+		dhi = ghi * erbsDiffuseFraction(kt)
+		dni = (ghi - dhi) / cosZenith
+	}
+
+	tilt := system.Tilt * rad
+	cosIncidence := cosZenith*math.Cos(tilt) + math.Sqrt(1-cosZenith*cosZenith)*math.Sin(tilt)*math.Cos((azimuth-system.Azimuth)*rad)
+	beam := dni * math.Max(cosIncidence, 0)
+	sky := dhi * (1 + math.Cos(tilt)) / 2
+	ground := ghi * groundAlbedo * (1 - math.Cos(tilt)) / 2
+	poa := beam + sky + ground
+
+	cellTemperature := airTemperature + poa*(pvNOCT-20)/800
+	power := system.CapacityKW * poa / 1000 * (1 + pvTemperatureCoefficient*(cellTemperature-25)) * pvPerformanceRatio
+	power = math.Max(power, 0)
+
+	hour.DNI = roundTo(dni, 1)
+	hour.DHI = roundTo(dhi, 1)
+	hour.POA = roundTo(poa, 1)
+	hour.CellTemperature = roundTo(cellTemperature, 1)
+	hour.PowerKW = roundTo(power, 3)
+	hour.EnergyKWh = hour.PowerKW // one hour at the mean power
+	return hour
+}
+
+// erbsDiffuseFraction estimates the diffuse share of global irradiance from
+// the clearness index kt (Erbs, Klein and Duffie, 1982)
+func erbsDiffuseFraction(kt float64) float64 {
+	switch {
+	case kt <= 0.22:
+		return 1 - 0.09*kt
+	case kt <= 0.8:
+		return 0.9511 - 0.1604*kt + 4.388*kt*kt - 16.638*math.Pow(kt, 3) + 12.336*math.Pow(kt, 4)
+	}
+	return 0.165
+}
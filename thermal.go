@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// ThermalIndices are quantities derived from temperature, humidity, wind and
+// irradiance for heat and cold safety. Temperatures are in °C. Heat index
+// and wind chill are only reported where their formulas are defined.
+type ThermalIndices struct {
+	DewPoint   float64  `json:"dew_point"`
+	HeatIndex  *float64 `json:"heat_index,omitempty"` // from 26.7°C (80°F)
+	WindChill  *float64 `json:"wind_chill,omitempty"` // up to 10°C with wind above 4.8 km/h
+	Humidex    float64  `json:"humidex"`
+	WBGT       float64  `json:"wbgt"`     // wet-bulb globe temperature estimate
+	UVIndex    float64  `json:"uv_index"` // measured for current weather, approximate in forecasts (see hourlyUVIndex)
+	UVCategory string   `json:"uv_category"`
+}
+
+// newThermalIndices derives the indices from air temperature in °C, relative
+// humidity from 0 to 1 as reported by Caiyun, wind speed in km/h and the UV
+// index
+func newThermalIndices(temperature, humidity, windSpeed, uvIndex float64) ThermalIndices {
+	rh := math.Min(math.Max(humidity*100, 1), 100)
+	indices := ThermalIndices{
+		DewPoint:   roundTo(dewPoint(temperature, rh), 1),
+		Humidex:    roundTo(humidex(temperature, rh), 1),
+		WBGT:       roundTo(wbgt(temperature, rh), 1),
+		UVIndex:    roundTo(uvIndex, 1),
+		UVCategory: uvCategory(uvIndex),
+	}
+	if temperature >= 26.7 {
+		hi := roundTo(heatIndex(temperature, rh), 1)
+		indices.HeatIndex = &hi
+	}
+	if temperature <= 10 && windSpeed > 4.8 {
+		wc := roundTo(windChill(temperature, windSpeed), 1)
+		indices.WindChill = &wc
+	}
+	return indices
+}
+
+// vaporPressure returns the water vapour pressure in hPa
+func vaporPressure(t, rh float64) float64 {
+	return rh / 100 * 6.105 * math.Exp(17.27*t/(237.7+t))
+}
+
+// dewPoint uses the Magnus formula
+func dewPoint(t, rh float64) float64 {
+	const a, b = 17.62, 243.12
+	gamma := math.Log(rh/100) + a*t/(b+t)
+	return b * gamma / (a - gamma)
+}
+
+// heatIndex follows the US National Weather Service algorithm: the Rothfusz
+// regression with its humidity adjustments, or Steadman's simple formula
+// when that gives less than 80°F
+func heatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh - 0.22475541*f*rh -
+			0.00683783*f*f - 0.05481717*rh*rh + 0.00122874*f*f*rh +
+			0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+// windChill uses the North American wind chill index with wind in km/h
+func windChill(t, v float64) float64 {
+	p := math.Pow(v, 0.16)
+	return 13.12 + 0.6215*t - 11.37*p + 0.3965*t*p
+}
+
+// humidex follows Environment Canada's definition based on the dew point
+func humidex(t, rh float64) float64 {
+	td := dewPoint(t, rh)
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/(273.15+td)))
+	return t + 0.5555*(e-10)
+}
+
+// wbgt uses the Australian Bureau of Meteorology approximation, which assumes
+// moderately strong sunshine and a light wind. It is meant for screening
+// against heat-safety thresholds, not as a measured WBGT.
+func wbgt(t, rh float64) float64 {
+	return 0.567*t + 0.393*vaporPressure(t, rh) + 3.94
+}
+
+// ultravioletCategoryPeak is a representative peak UV index for each step
+// of Caiyun's daily ultraviolet category, the middle of the ranges in
+// realtimeUVLevel
+var ultravioletCategoryPeak = map[int]float64{1: 1.5, 2: 4, 3: 6, 4: 8.5, 5: 11}
+
+// hourlyUVIndex approximates the UV index of each hourly forecast point.
+// Caiyun forecasts no hourly UV index, only a daily ultraviolet category, so
+// the category's peak is spread over the day's hours in proportion to their
+// share of the day's highest irradiance. Irradiance only gives the shape of
+// the day: it does not convert to erythemal UV on its own. Days without a
+// category fall back to irradiance / 100, a rough clear-sky approximation.
+func hourlyUVIndex(resp *CaiyunAPIResponse) []float64 {
+	points := resp.Result.Hourly.Dswrf
+	// Hourly and daily timestamps carry the location's offset
+	day := func(raw string) string {
+		t, err := parseCaiyunTime(raw)
+		if err != nil {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+
+	peakIrradiance := make(map[string]float64)
+	for _, point := range points {
+		key := day(point.Datetime)
+		peakIrradiance[key] = math.Max(peakIrradiance[key], point.Value)
+	}
+	peakUV := make(map[string]float64)
+	for _, value := range resp.Result.Daily.LifeIndex["ultraviolet"] {
+		category, err := strconv.Atoi(value.Index)
+		if peak, ok := ultravioletCategoryPeak[category]; err == nil && ok {
+			peakUV[day(value.Date)] = peak
+		}
+	}
+
+	result := make([]float64, len(points))
+	for i, point := range points {
+		key := day(point.Datetime)
+		peak, ok := peakUV[key]
+		switch {
+		case point.Value <= 0:
+		case ok && key != "":
+			result[i] = peak * point.Value / peakIrradiance[key]
+		default:
+			result[i] = point.Value / 100
+		}
+	}
+	return result
+}
+
+// uvCategory maps a UV index to the WHO exposure categories
+func uvCategory(uvi float64) string {
+	switch {
+	case uvi < 3:
+		return "low"
+	case uvi < 6:
+		return "moderate"
+	case uvi < 8:
+		return "high"
+	case uvi < 11:
+		return "very_high"
+	}
+	return "extreme"
+}
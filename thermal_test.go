@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHourlyUVIndex(t *testing.T) {
+	var resp CaiyunAPIResponse
+	err := json.Unmarshal([]byte(`{
+		"result": {
+			"hourly": {"dswrf": [
+				{"datetime": "2026-07-01T06:00+08:00", "value": 0},
+				{"datetime": "2026-07-01T09:00+08:00", "value": 400},
+				{"datetime": "2026-07-01T12:00+08:00", "value": 800},
+				{"datetime": "2026-07-02T12:00+08:00", "value": 600},
+				{"datetime": "2026-07-03T12:00+08:00", "value": 700}
+			]},
+			"daily": {"life_index": {"ultraviolet": [
+				{"date": "2026-07-01T00:00+08:00", "index": "5", "desc": "很强"},
+				{"date": "2026-07-02T00:00+08:00", "index": "2", "desc": "弱"}
+			]}}
+		}
+	}`), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := hourlyUVIndex(&resp)
+	want := []float64{
+		0,   // night
+		5.5, // half the day's peak irradiance, category 5
+		11,  // the day's peak
+		4,   // the peak of a category 2 day
+		7,   // no category: irradiance / 100
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d values, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if roundTo(got[i], 2) != want[i] {
+			t.Errorf("hour %d: UV index %.2f, want %.2f", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minForecastRunInterval keeps at most one stored forecast run per hour
+	minForecastRunInterval = time.Hour
+
+	// rainThreshold is the intensity in mm/h above which it counts as raining
+	rainThreshold = 0.1
+
+	// maxObservationOffset is how far an observation may be from a forecast
+	// hour and still verify it
+	maxObservationOffset = 30 * time.Minute
+
+	// minDailyCoverage is how much of a day observations must span for its
+	// extremes and rainfall to be verified
+	minDailyCoverage = 20 * time.Hour
+)
+
+// hourlyLeadBuckets are the upper bounds, in hours, of the lead time groups
+var hourlyLeadBuckets = []int{3, 6, 12, 24, 48}
+
+// ForecastRun is a stored forecast as issued at one point in time
+type ForecastRun struct {
+	IssuedAt time.Time             `json:"issued_at"`
+	Hourly   []HourlyForecastPoint `json:"hourly"`
+	Daily    []DailyForecastPoint  `json:"daily"`
+}
+
+// HourlyForecastPoint is the forecast for one hour
+type HourlyForecastPoint struct {
+	Time                     time.Time `json:"time"`
+	Temperature              float64   `json:"temperature"`
+	Precipitation            float64   `json:"precipitation"` // intensity, mm/h
+	PrecipitationProbability int       `json:"precipitation_probability"`
+}
+
+// DailyForecastPoint is the forecast for one day
+type DailyForecastPoint struct {
+	Date                     time.Time `json:"date"`
+	TemperatureMin           float64   `json:"temperature_min"`
+	TemperatureMax           float64   `json:"temperature_max"`
+	Precipitation            float64   `json:"precipitation"` // max intensity, mm/h
+	PrecipitationProbability int       `json:"precipitation_probability"`
+}
+
+// ForecastStore keeps every fetched forecast run per location as NDJSON
+type ForecastStore struct {
+	dir string
+
+	mu   sync.RWMutex
+	runs map[string][]ForecastRun
+}
+
+// newForecastStore opens the store in dir, loading existing runs
+func newForecastStore(dir string) (*ForecastStore, error) {
+	fs := &ForecastStore{dir: dir, runs: make(map[string][]ForecastRun)}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		runs, err := readNDJSONFile[ForecastRun](file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		sort.Slice(runs, func(i, j int) bool { return runs[i].IssuedAt.Before(runs[j].IssuedAt) })
+		fs.runs[strings.TrimSuffix(filepath.Base(file), ".ndjson")] = runs
+	}
+	return fs, nil
+}
+
+// Record stores the hourly and daily forecast of resp unless a run was
+// stored for location within the last minForecastRunInterval
+func (fs *ForecastStore) Record(location string, resp *CaiyunAPIResponse) {
+	times := newCaiyunTimes(resp)
+	issuedAt, ok := times.unix("server_time", resp.ServerTime)
+	if !ok {
+		return
+	}
+	run := ForecastRun{IssuedAt: issuedAt}
+
+	hourly := resp.Result.Hourly
+	for i, temp := range hourly.Temperature {
+		t, ok := times.parse("hourly.temperature.datetime", temp.Datetime)
+		if !ok {
+			continue
+		}
+		point := HourlyForecastPoint{Time: t, Temperature: temp.Value}
+		if i < len(hourly.Precipitation) {
+			point.Precipitation = hourly.Precipitation[i].Value
+			point.PrecipitationProbability = hourly.Precipitation[i].Probability
+		}
+		run.Hourly = append(run.Hourly, point)
+	}
+
+	daily := resp.Result.Daily
+	for i, temp := range daily.Temperature {
+		date, ok := times.parse("daily.temperature.date", temp.Date)
+		if !ok {
+			continue
+		}
+		point := DailyForecastPoint{Date: date, TemperatureMin: temp.Min, TemperatureMax: temp.Max}
+		if i < len(daily.Precipitation) {
+			point.Precipitation = daily.Precipitation[i].Max
+			point.PrecipitationProbability = daily.Precipitation[i].Probability
+		}
+		run.Daily = append(run.Daily, point)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	runs := fs.runs[location]
+	if n := len(runs); n > 0 && run.IssuedAt.Sub(runs[n-1].IssuedAt) < minForecastRunInterval {
+		return
+	}
+	if err := appendNDJSONFile(filepath.Join(fs.dir, location+".ndjson"), run); err != nil {
+		log.Printf("Failed to record forecast for %s: %v", location, err)
+		return
+	}
+	fs.runs[location] = append(runs, run)
+}
+
+// Runs returns the runs for location issued before to
+func (fs *ForecastStore) Runs(location string, to time.Time) []ForecastRun {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	runs := fs.runs[location]
+	end := sort.Search(len(runs), func(i int) bool { return !runs[i].IssuedAt.Before(to) })
+	return append([]ForecastRun(nil), runs[:end]...)
+}
+
+// ErrorReport summarizes forecast minus observed errors
+type ErrorReport struct {
+	Samples int     `json:"samples"`
+	Bias    float64 `json:"bias"`
+	MAE     float64 `json:"mae"`
+	RMSE    float64 `json:"rmse"`
+}
+
+// PrecipitationReport is the rain/no-rain contingency table with its scores.
+// Scores are omitted when undefined for the samples seen.
+type PrecipitationReport struct {
+	Hits             int      `json:"hits"`
+	Misses           int      `json:"misses"`
+	FalseAlarms      int      `json:"false_alarms"`
+	CorrectNegatives int      `json:"correct_negatives"`
+	HitRate          *float64 `json:"hit_rate,omitempty"`
+	FalseAlarmRatio  *float64 `json:"false_alarm_ratio,omitempty"`
+	Accuracy         *float64 `json:"accuracy,omitempty"`
+}
+
+// HourlyVerification groups hourly forecasts by lead time
+type HourlyVerification struct {
+	Lead          string              `json:"lead"`
+	Temperature   ErrorReport         `json:"temperature"`
+	Precipitation PrecipitationReport `json:"precipitation"`
+}
+
+// DailyVerification groups daily forecasts by lead time in days
+type DailyVerification struct {
+	LeadDays       int                 `json:"lead_days"`
+	TemperatureMin ErrorReport         `json:"temperature_min"`
+	TemperatureMax ErrorReport         `json:"temperature_max"`
+	Precipitation  PrecipitationReport `json:"precipitation"`
+}
+
+// VerificationReport compares stored forecasts with observations
+type VerificationReport struct {
+	Location string               `json:"location"`
+	From     time.Time            `json:"from"`
+	To       time.Time            `json:"to"`
+	Runs     int                  `json:"runs"`
+	Hourly   []HourlyVerification `json:"hourly"`
+	Daily    []DailyVerification  `json:"daily"`
+}
+
+type errorStats struct {
+	n                  int
+	sum, sumAbs, sumSq float64
+}
+
+func (e *errorStats) add(diff float64) {
+	e.n++
+	e.sum += diff
+	e.sumAbs += math.Abs(diff)
+	e.sumSq += diff * diff
+}
+
+func (e errorStats) report() ErrorReport {
+	if e.n == 0 {
+		return ErrorReport{}
+	}
+	n := float64(e.n)
+	return ErrorReport{
+		Samples: e.n,
+		Bias:    roundTo(e.sum/n, 2),
+		MAE:     roundTo(e.sumAbs/n, 2),
+		RMSE:    roundTo(math.Sqrt(e.sumSq/n), 2),
+	}
+}
+
+type contingency struct {
+	hits, misses, falseAlarms, correctNegatives int
+}
+
+func (c *contingency) add(forecast, observed bool) {
+	switch {
+	case forecast && observed:
+		c.hits++
+	case !forecast && observed:
+		c.misses++
+	case forecast && !observed:
+		c.falseAlarms++
+	default:
+		c.correctNegatives++
+	}
+}
+
+func (c contingency) report() PrecipitationReport {
+	ratio := func(num, den int) *float64 {
+		if den == 0 {
+			return nil
+		}
+		v := roundTo(float64(num)/float64(den), 3)
+		return &v
+	}
+	return PrecipitationReport{
+		Hits:             c.hits,
+		Misses:           c.misses,
+		FalseAlarms:      c.falseAlarms,
+		CorrectNegatives: c.correctNegatives,
+		HitRate:          ratio(c.hits, c.hits+c.misses),
+		FalseAlarmRatio:  ratio(c.falseAlarms, c.hits+c.falseAlarms),
+		Accuracy:         ratio(c.hits+c.correctNegatives, c.hits+c.misses+c.falseAlarms+c.correctNegatives),
+	}
+}
+
+// verifyForecasts scores every forecast targeting [from, to) against the
+// observations recorded for it
+func verifyForecasts(location string, runs []ForecastRun, observations []Observation, from, to time.Time) VerificationReport {
+	report := VerificationReport{
+		Location: location,
+		From:     from,
+		To:       to,
+		Runs:     len(runs),
+		Hourly:   []HourlyVerification{},
+		Daily:    []DailyVerification{},
+	}
+
+	type hourlyStats struct {
+		temperature   errorStats
+		precipitation contingency
+	}
+	hourly := make([]hourlyStats, len(hourlyLeadBuckets)+1)
+
+	type dailyStats struct {
+		min, max      errorStats
+		precipitation contingency
+	}
+	daily := make(map[int]*dailyStats)
+	amounts := precipitationAmounts(observations)
+
+	for _, run := range runs {
+		for _, point := range run.Hourly {
+			if point.Time.Before(from) || !point.Time.Before(to) {
+				continue
+			}
+			obs, ok := nearestObservation(observations, point.Time)
+			if !ok {
+				continue
+			}
+			lead := int(math.Max(0, point.Time.Sub(run.IssuedAt).Hours()))
+			bucket := sort.SearchInts(hourlyLeadBuckets, lead+1)
+			hourly[bucket].temperature.add(point.Temperature - obs.Temperature)
+			hourly[bucket].precipitation.add(point.Precipitation >= rainThreshold, obs.Precipitation >= rainThreshold)
+		}
+
+		for _, point := range run.Daily {
+			dayEnd := point.Date.Add(24 * time.Hour)
+			if point.Date.Before(from) || dayEnd.After(to) {
+				continue
+			}
+			start := sort.Search(len(observations), func(i int) bool { return !observations[i].Time.Before(point.Date) })
+			end := sort.Search(len(observations), func(i int) bool { return !observations[i].Time.Before(dayEnd) })
+			if end-start < 2 || observations[end-1].Time.Sub(observations[start].Time) < minDailyCoverage {
+				continue
+			}
+
+			minT, maxT, rain := math.Inf(1), math.Inf(-1), 0.0
+			for i := start; i < end; i++ {
+				minT = math.Min(minT, observations[i].Temperature)
+				maxT = math.Max(maxT, observations[i].Temperature)
+				rain += amounts[i]
+			}
+
+			issuedDay := time.Date(run.IssuedAt.Year(), run.IssuedAt.Month(), run.IssuedAt.Day(), 0, 0, 0, 0, point.Date.Location())
+			leadDays := int(math.Round(point.Date.Sub(issuedDay).Hours() / 24))
+			if leadDays < 0 {
+				continue
+			}
+			stats := daily[leadDays]
+			if stats == nil {
+				stats = &dailyStats{}
+				daily[leadDays] = stats
+			}
+			stats.min.add(point.TemperatureMin - minT)
+			stats.max.add(point.TemperatureMax - maxT)
+			stats.precipitation.add(point.Precipitation >= rainThreshold, rain >= rainThreshold)
+		}
+	}
+
+	for i, stats := range hourly {
+		if stats.temperature.n == 0 {
+			continue
+		}
+		report.Hourly = append(report.Hourly, HourlyVerification{
+			Lead:          hourlyLeadLabel(i),
+			Temperature:   stats.temperature.report(),
+			Precipitation: stats.precipitation.report(),
+		})
+	}
+
+	var leads []int
+	for lead := range daily {
+		leads = append(leads, lead)
+	}
+	sort.Ints(leads)
+	for _, lead := range leads {
+		report.Daily = append(report.Daily, DailyVerification{
+			LeadDays:       lead,
+			TemperatureMin: daily[lead].min.report(),
+			TemperatureMax: daily[lead].max.report(),
+			Precipitation:  daily[lead].precipitation.report(),
+		})
+	}
+	return report
+}
+
+// nearestObservation finds the observation closest to t within
+// maxObservationOffset. observations must be sorted by time.
+func nearestObservation(observations []Observation, t time.Time) (Observation, bool) {
+	i := sort.Search(len(observations), func(i int) bool { return !observations[i].Time.Before(t) })
+	best, bestOffset := -1, maxObservationOffset+1
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(observations) {
+			continue
+		}
+		offset := observations[j].Time.Sub(t)
+		if offset < 0 {
+			offset = -offset
+		}
+		if offset < bestOffset {
+			best, bestOffset = j, offset
+		}
+	}
+	if best == -1 {
+		return Observation{}, false
+	}
+	return observations[best], true
+}
+
+func hourlyLeadLabel(bucket int) string {
+	lower := 0
+	if bucket > 0 {
+		lower = hourlyLeadBuckets[bucket-1]
+	}
+	if bucket == len(hourlyLeadBuckets) {
+		return fmt.Sprintf("%dh+", lower)
+	}
+	return fmt.Sprintf("%d-%dh", lower, hourlyLeadBuckets[bucket])
+}
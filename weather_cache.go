@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// weatherUpdateFunc is called with every freshly fetched report
+type weatherUpdateFunc func(location string, resp *CaiyunAPIResponse)
+
+type cachedWeather struct {
+	resp      *CaiyunAPIResponse
+	fetchedAt time.Time
+}
+
+// weatherFetch is a fetch in flight that concurrent callers wait for
+type weatherFetch struct {
+	done chan struct{}
+	resp *CaiyunAPIResponse
+	err  error
+}
+
+// WeatherCache keeps the latest Caiyun report per location, refreshes watched
+// locations in the background and notifies listeners of new data. There is
+// at most one fetch per location at a time, and listeners see a location's
+// reports one at a time in the order they were fetched.
+type WeatherCache struct {
+	fetch func(location string) (*CaiyunAPIResponse, error)
+	ttl   time.Duration
+
+	mu        sync.RWMutex
+	entries   map[string]cachedWeather
+	fetching  map[string]*weatherFetch
+	watched   map[string]int
+	listeners []weatherUpdateFunc
+}
+
+func newWeatherCache(fetch func(location string) (*CaiyunAPIResponse, error), ttl time.Duration) *WeatherCache {
+	return &WeatherCache{
+		fetch:    fetch,
+		ttl:      ttl,
+		entries:  make(map[string]cachedWeather),
+		fetching: make(map[string]*weatherFetch),
+		watched:  make(map[string]int),
+	}
+}
+
+// OnUpdate registers fn to be called after each successful fetch
+func (wc *WeatherCache) OnUpdate(fn weatherUpdateFunc) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.listeners = append(wc.listeners, fn)
+}
+
+// Get returns the cached report for location, fetching a new one when the
+// cached copy is older than the TTL
+func (wc *WeatherCache) Get(location string) (*CaiyunAPIResponse, error) {
+	wc.mu.RLock()
+	entry, ok := wc.entries[location]
+	wc.mu.RUnlock()
+
+	if ok && time.Since(entry.fetchedAt) < wc.ttl {
+		return entry.resp, nil
+	}
+	return wc.Refresh(location)
+}
+
+// Peek returns the cached report for location without fetching, or nil
+func (wc *WeatherCache) Peek(location string) *CaiyunAPIResponse {
+	wc.mu.RLock()
+	defer wc.mu.RUnlock()
+	return wc.entries[location].resp
+}
+
+// Refresh fetches a new report for location and notifies listeners. Callers
+// arriving while a fetch for location is in flight share its result.
+func (wc *WeatherCache) Refresh(location string) (*CaiyunAPIResponse, error) {
+	wc.mu.Lock()
+	if f, ok := wc.fetching[location]; ok {
+		wc.mu.Unlock()
+		<-f.done
+		return f.resp, f.err
+	}
+	f := &weatherFetch{done: make(chan struct{})}
+	wc.fetching[location] = f
+	wc.mu.Unlock()
+
+	// The fetch stays registered until the listeners are done, so the next
+	// fetch for location cannot overtake this one
+	defer func() {
+		wc.mu.Lock()
+		delete(wc.fetching, location)
+		wc.mu.Unlock()
+	}()
+
+	f.resp, f.err = wc.fetch(location)
+	if f.err != nil {
+		close(f.done)
+		return nil, f.err
+	}
+
+	wc.mu.Lock()
+	wc.entries[location] = cachedWeather{resp: f.resp, fetchedAt: time.Now()}
+	listeners := append([]weatherUpdateFunc(nil), wc.listeners...)
+	wc.mu.Unlock()
+	close(f.done)
+
+	for _, fn := range listeners {
+		fn(location, f.resp)
+	}
+	return f.resp, nil
+}
+
+// Watch asks the background refresher to keep location up to date. Each
+// call must be paired with Unwatch.
+func (wc *WeatherCache) Watch(location string) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.watched[location]++
+}
+
+// Unwatch releases a Watch
+func (wc *WeatherCache) Unwatch(location string) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.watched[location] <= 1 {
+		delete(wc.watched, location)
+		return
+	}
+	wc.watched[location]--
+}
+
+// Run refreshes every watched location each interval until ctx is done
+func (wc *WeatherCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		wc.mu.RLock()
+		locations := make([]string, 0, len(wc.watched))
+		for location := range wc.watched {
+			locations = append(locations, location)
+		}
+		wc.mu.RUnlock()
+
+		for _, location := range locations {
+			if _, err := wc.Refresh(location); err != nil {
+				log.Printf("Failed to refresh weather for %s: %v", location, err)
+			}
+		}
+	}
+}
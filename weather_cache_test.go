@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWeatherCacheSharesConcurrentFetches(t *testing.T) {
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	cache := newWeatherCache(func(location string) (*CaiyunAPIResponse, error) {
+		n := fetches.Add(1)
+		<-release
+		return &CaiyunAPIResponse{ServerTime: n}, nil
+	}, time.Minute)
+
+	var notified []int64
+	cache.OnUpdate(func(location string, resp *CaiyunAPIResponse) {
+		notified = append(notified, resp.ServerTime)
+	})
+
+	var wg sync.WaitGroup
+	results := make([]*CaiyunAPIResponse, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cache.Get("loc")
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = resp
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+	for i, resp := range results {
+		if resp == nil || resp.ServerTime != 1 {
+			t.Errorf("caller %d got %+v, want the shared response", i, resp)
+		}
+	}
+	if len(notified) != 1 {
+		t.Errorf("listeners notified %d times, want 1", len(notified))
+	}
+}
+
+func TestWeatherCacheNotifiesInFetchOrder(t *testing.T) {
+	var fetches atomic.Int64
+	cache := newWeatherCache(func(location string) (*CaiyunAPIResponse, error) {
+		return &CaiyunAPIResponse{ServerTime: fetches.Add(1)}, nil
+	}, time.Minute)
+
+	var mu sync.Mutex
+	var notified []int64
+	busy := false
+	cache.OnUpdate(func(location string, resp *CaiyunAPIResponse) {
+		mu.Lock()
+		if busy {
+			t.Error("listener called concurrently for one location")
+		}
+		busy = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		busy = false
+		notified = append(notified, resp.ServerTime)
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Refresh("loc"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 1; i < len(notified); i++ {
+		if notified[i] <= notified[i-1] {
+			t.Fatalf("listeners saw reports out of order: %v", notified)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	streamEventRealtime = "realtime"
+	streamEventAlert    = "alert"
+
+	// streamHistorySize is the number of events kept per location for
+	// clients resuming with Last-Event-ID
+	streamHistorySize = 100
+
+	// streamLocationTTL is how long the history of a location nobody
+	// follows is kept after its last event
+	streamLocationTTL = 6 * time.Hour
+)
+
+var errTooManyStreams = errors.New("too many open streams")
+
+// streamEvent is a live update pushed to streaming clients
+type streamEvent struct {
+	ID       int64
+	Location string
+	Name     string
+	Data     any
+}
+
+// realtimeUpdate is the payload of a realtime stream event
+type realtimeUpdate struct {
+	Location    string         `json:"location"`
+	Current     CurrentWeather `json:"current"`
+	Summary     WeatherSummary `json:"summary"`
+	LastUpdated time.Time      `json:"last_updated"`
+}
+
+// streamSubscriber receives the events of the locations it subscribed to.
+// A subscriber that falls too far behind is dropped: Dropped is closed and
+// the connection should end so that the client reconnects and resumes from
+// history instead of silently missing events.
+type streamSubscriber struct {
+	Events  chan streamEvent
+	Dropped chan struct{}
+}
+
+func newStreamSubscriber(buffer int) *streamSubscriber {
+	return &streamSubscriber{
+		Events:  make(chan streamEvent, buffer),
+		Dropped: make(chan struct{}),
+	}
+}
+
+// hubLocation is the retained state of one location
+type hubLocation struct {
+	history    []streamEvent
+	trimmedID  int64 // newest event dropped from history
+	latest     *streamEvent
+	serverTime int64
+	updated    time.Time
+}
+
+// WeatherHub fans out realtime updates and alert changes to streaming
+// clients and keeps a short history per location for resumption
+type WeatherHub struct {
+	maxPerClient int
+	maxTotal     int
+	climate      *ClimateStore
+
+	mu          sync.Mutex
+	seq         int64
+	locations   map[string]*hubLocation
+	lastSweep   time.Time
+	subscribers map[string]map[*streamSubscriber]struct{}
+	clients     map[string]int
+	total       int
+}
+
+func newWeatherHub(maxPerClient, maxTotal int, climate *ClimateStore) *WeatherHub {
+	return &WeatherHub{
+		maxPerClient: maxPerClient,
+		maxTotal:     maxTotal,
+		climate:      climate,
+		locations:    make(map[string]*hubLocation),
+		lastSweep:    time.Now(),
+		subscribers:  make(map[string]map[*streamSubscriber]struct{}),
+		clients:      make(map[string]int),
+	}
+}
+
+// Acquire reserves a streaming connection for client. The returned release
+// func must be called when the connection closes.
+func (h *WeatherHub) Acquire(client string) (func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.total >= h.maxTotal || h.clients[client] >= h.maxPerClient {
+		return nil, errTooManyStreams
+	}
+	h.total++
+	h.clients[client]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.total--
+			if h.clients[client]--; h.clients[client] <= 0 {
+				delete(h.clients, client)
+			}
+		})
+	}, nil
+}
+
+// Subscribe delivers future events for location to sub. It does nothing once
+// sub has been dropped.
+func (h *WeatherHub) Subscribe(location string, sub *streamSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-sub.Dropped:
+		return
+	default:
+	}
+	if h.subscribers[location] == nil {
+		h.subscribers[location] = make(map[*streamSubscriber]struct{})
+	}
+	h.subscribers[location][sub] = struct{}{}
+}
+
+// Unsubscribe stops delivering events for location to sub
+func (h *WeatherHub) Unsubscribe(location string, sub *streamSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribeLocked(location, sub)
+}
+
+func (h *WeatherHub) unsubscribeLocked(location string, sub *streamSubscriber) {
+	delete(h.subscribers[location], sub)
+	if len(h.subscribers[location]) == 0 {
+		delete(h.subscribers, location)
+	}
+}
+
+// Since returns the events for location after lastID. ok is false when
+// events after lastID are no longer retained, in which case the caller
+// should start over from Latest.
+func (h *WeatherHub) Since(location string, lastID int64) (events []streamEvent, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastID > h.seq {
+		return nil, false
+	}
+	loc := h.locations[location]
+	if loc == nil {
+		// Nothing retained: only a client that has seen every event is
+		// up to date
+		return nil, lastID == h.seq
+	}
+	if lastID < loc.trimmedID {
+		return nil, false
+	}
+	for _, event := range loc.history {
+		if event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+// Latest returns the most recent realtime event for location
+func (h *WeatherHub) Latest(location string) (streamEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if loc := h.locations[location]; loc != nil && loc.latest != nil {
+		return *loc.latest, true
+	}
+	return streamEvent{}, false
+}
+
+// PublishWeather pushes a realtime event unless resp is the same report as
+// the one published last
+func (h *WeatherHub) PublishWeather(location string, resp *CaiyunAPIResponse) {
+	light := ConvertToLightModel(resp, LangZH)
+	if light == nil {
+		return
+	}
+	h.climate.applyAnomalies(location, light)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	loc := h.locationLocked(location)
+	if loc.serverTime == resp.ServerTime {
+		return
+	}
+	loc.serverTime = resp.ServerTime
+
+	event := h.publishLocked(location, streamEventRealtime, realtimeUpdate{
+		Location:    location,
+		Current:     light.Current,
+		Summary:     light.Summary,
+		LastUpdated: light.LastUpdated,
+	})
+	loc.latest = &event
+}
+
+// PublishAlerts pushes one alert event per change
+func (h *WeatherHub) PublishAlerts(location string, changes []AlertChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, change := range changes {
+		h.publishLocked(location, streamEventAlert, change)
+	}
+}
+
+// locationLocked returns the state of location, creating it and expiring
+// locations nobody has followed for streamLocationTTL. Callers must hold
+// h.mu.
+func (h *WeatherHub) locationLocked(location string) *hubLocation {
+	now := time.Now()
+	if now.Sub(h.lastSweep) > streamLocationTTL/4 {
+		for key, loc := range h.locations {
+			if len(h.subscribers[key]) == 0 && now.Sub(loc.updated) > streamLocationTTL {
+				delete(h.locations, key)
+			}
+		}
+		h.lastSweep = now
+	}
+
+	loc := h.locations[location]
+	if loc == nil {
+		loc = &hubLocation{trimmedID: h.seq}
+		h.locations[location] = loc
+	}
+	loc.updated = now
+	return loc
+}
+
+// publishLocked records and fans out an event. Callers must hold h.mu.
+func (h *WeatherHub) publishLocked(location, name string, data any) streamEvent {
+	loc := h.locationLocked(location)
+	h.seq++
+	event := streamEvent{ID: h.seq, Location: location, Name: name, Data: data}
+
+	loc.history = append(loc.history, event)
+	if len(loc.history) > streamHistorySize {
+		loc.trimmedID = loc.history[len(loc.history)-streamHistorySize-1].ID
+		loc.history = loc.history[len(loc.history)-streamHistorySize:]
+	}
+
+	for sub := range h.subscribers[location] {
+		select {
+		case sub.Events <- event:
+		default:
+			h.dropLocked(sub)
+		}
+	}
+	return event
+}
+
+// dropLocked disconnects a subscriber that is not keeping up. Callers must
+// hold h.mu.
+func (h *WeatherHub) dropLocked(sub *streamSubscriber) {
+	for location, subs := range h.subscribers {
+		if _, ok := subs[sub]; ok {
+			h.unsubscribeLocked(location, sub)
+		}
+	}
+	close(sub.Dropped)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// maxSocketSubscriptions bounds the locations one connection may follow
+const maxSocketSubscriptions = 20
+
+// socketRequest is a message sent by a WebSocket client
+type socketRequest struct {
+	Action    string   `json:"action"` // "subscribe" or "unsubscribe"
+	Location  string   `json:"location,omitempty"`
+	Locations []string `json:"locations,omitempty"`
+}
+
+// socketMessage is a message sent to a WebSocket client
+type socketMessage struct {
+	Type     string `json:"type"`
+	ID       int64  `json:"id,omitempty"`
+	Location string `json:"location,omitempty"`
+	Data     any    `json:"data,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// serveWeatherSocket runs one WebSocket connection. Clients subscribe to
+// location IDs or geopos values and receive the same realtime and alert
+// events as the SSE stream.
+func (s *Server) serveWeatherSocket(ws *websocket.Conn) {
+	sub := newStreamSubscriber(64)
+	requests := make(chan socketRequest)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	subscribed := make(map[string]bool)
+
+	defer close(done)
+
+	defer func() {
+		for location := range subscribed {
+			s.hub.Unsubscribe(location, sub)
+			s.cache.Unwatch(location)
+		}
+	}()
+
+	go func() {
+		defer close(closed)
+		for {
+			var req socketRequest
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	send := func(msg socketMessage) bool {
+		ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return websocket.JSON.Send(ws, msg) == nil
+	}
+
+	subscribe := func(name string) bool {
+		location, err := s.config.resolveLocation(name)
+		if err != nil {
+			return send(socketMessage{Type: "error", Location: name, Error: err.Error()})
+		}
+		if !subscribed[location] {
+			if len(subscribed) >= maxSocketSubscriptions {
+				return send(socketMessage{Type: "error", Location: name, Error: "too many subscriptions"})
+			}
+			subscribed[location] = true
+			s.hub.Subscribe(location, sub)
+			s.cache.Watch(location)
+		}
+		if !send(socketMessage{Type: "subscribed", Location: location}) {
+			return false
+		}
+		if latest, ok := s.hub.Latest(location); ok {
+			return send(socketMessage{Type: latest.Name, ID: latest.ID, Location: location, Data: latest.Data})
+		}
+		go func() {
+			if _, err := s.cache.Get(location); err != nil {
+				log.Printf("Failed to load weather for socket %s: %v", location, err)
+			}
+		}()
+		return true
+	}
+
+	unsubscribe := func(name string) bool {
+		location, err := s.config.resolveLocation(name)
+		if err != nil {
+			return send(socketMessage{Type: "error", Location: name, Error: err.Error()})
+		}
+		if subscribed[location] {
+			delete(subscribed, location)
+			s.hub.Unsubscribe(location, sub)
+			s.cache.Unwatch(location)
+		}
+		return send(socketMessage{Type: "unsubscribed", Location: location})
+	}
+
+	handleRequest := func(req socketRequest) bool {
+		var handle func(string) bool
+		switch req.Action {
+		case "subscribe":
+			handle = subscribe
+		case "unsubscribe":
+			handle = unsubscribe
+		default:
+			return send(socketMessage{Type: "error", Error: "unknown action " + req.Action})
+		}
+
+		names := req.Locations
+		if req.Location != "" {
+			names = append(names, req.Location)
+		}
+		for _, name := range names {
+			if !handle(name) {
+				return false
+			}
+		}
+		return true
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	deadline := time.After(streamMaxDuration)
+
+	for {
+		ok := true
+		select {
+		case <-closed:
+			return
+		case <-deadline:
+			return
+		case <-heartbeat.C:
+			ok = send(socketMessage{Type: "heartbeat"})
+		case <-sub.Dropped:
+			// Too far behind: close so the client reconnects and
+			// subscribes again
+			return
+		case event := <-sub.Events:
+			ok = send(socketMessage{Type: event.Name, ID: event.ID, Location: event.Location, Data: event.Data})
+		case req := <-requests:
+			ok = handleRequest(req)
+		}
+		if !ok {
+			return
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests it receives and answers with the
+// given status codes in turn, repeating the last one
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, receivedWebhook{req.Header.Clone(), body})
+	status := r.statuses[min(len(r.requests), len(r.statuses))-1]
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+func newTestSubscriptionStore(t *testing.T, path string) *SubscriptionStore {
+	t.Helper()
+	store, err := newSubscriptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.allowPrivate = true
+	store.backoff = 10 * time.Millisecond
+	return store
+}
+
+func addTestSubscription(t *testing.T, store *SubscriptionStore, url string) Subscription {
+	t.Helper()
+	sub, err := store.Add(Subscription{
+		URL:        url,
+		Location:   "116.3062,39.9841",
+		Conditions: []SubscriptionCondition{{Kind: ConditionAlert}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// queueTestDelivery queues a rainstorm alert event the way Evaluate does
+func queueTestDelivery(store *SubscriptionStore, id string) queuedDelivery {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, sub := range store.state.Subscriptions {
+		if sub.ID == id {
+			alert := WeatherAlert{ID: "a1", Type: AlertTypeRainstorm, Color: AlertColorYellow}
+			event := WebhookEvent{Kind: ConditionAlert, Location: sub.Location, Alert: &alert, key: "alert:a1:yellow"}
+			q := store.enqueueLocked(sub, event, time.Now())
+			store.saveLocked()
+			return q
+		}
+	}
+	panic("subscription not found")
+}
+
+func TestWebhookDeliverySignsAndRetries(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	store := newTestSubscriptionStore(t, "")
+	sub := addTestSubscription(t, store, server.URL)
+	q := queueTestDelivery(store, sub.ID)
+	store.deliver(q.sub, q.delivery)
+
+	requests := receiver.received()
+	if len(requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(requests))
+	}
+	for i, req := range requests {
+		timestamp := req.header.Get("X-LakeLink-Timestamp")
+		want := "sha256=" + signWebhook(sub.Secret, timestamp, req.body)
+		if got := req.header.Get("X-LakeLink-Signature"); got != want {
+			t.Errorf("request %d: signature %q, want %q", i, got, want)
+		}
+		if got := req.header.Get("X-LakeLink-Delivery"); got != q.delivery.ID {
+			t.Errorf("request %d: delivery header %q, want %q", i, got, q.delivery.ID)
+		}
+		if got := req.header.Get("X-LakeLink-Event"); got != string(ConditionAlert) {
+			t.Errorf("request %d: event header %q, want %q", i, got, ConditionAlert)
+		}
+	}
+
+	deliveries := store.Deliveries(sub.ID)
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if !d.Delivered || d.Attempts != 3 || d.StatusCode != http.StatusOK || d.Error != "" || !d.NextAttemptAt.IsZero() {
+		t.Errorf("delivery = %+v, want delivered after 3 attempts with status 200", d)
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	store := newTestSubscriptionStore(t, "")
+	store.maxAttempts = 3
+	sub := addTestSubscription(t, store, server.URL)
+	q := queueTestDelivery(store, sub.ID)
+	store.deliver(q.sub, q.delivery)
+
+	if n := len(receiver.received()); n != 3 {
+		t.Fatalf("receiver got %d requests, want 3", n)
+	}
+	d := store.Deliveries(sub.ID)[0]
+	if d.Delivered || d.Attempts != 3 || d.StatusCode != http.StatusServiceUnavailable || d.Error == "" || !d.NextAttemptAt.IsZero() {
+		t.Errorf("delivery = %+v, want failed after 3 attempts with status 503", d)
+	}
+}
+
+func TestWebhookDeliveryResumesAfterRestart(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	store := newTestSubscriptionStore(t, path)
+	sub := addTestSubscription(t, store, server.URL)
+	queueTestDelivery(store, sub.ID) // saved but never delivered
+
+	restarted := newTestSubscriptionStore(t, path)
+	restarted.Resume()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !restarted.Deliveries(sub.ID)[0].Delivered {
+		if time.Now().After(deadline) {
+			t.Fatal("pending delivery was not resumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(receiver.received()); n != 1 {
+		t.Errorf("receiver got %d requests, want 1", n)
+	}
+}
+
+func TestWebhookRejectsPrivateTargets(t *testing.T) {
+	store, err := newSubscriptionStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"https://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[fd00::1]/hook",
+		"ftp://203.0.113.1/hook",
+	} {
+		if _, err := store.Add(Subscription{
+			URL:        url,
+			Location:   "116.3062,39.9841",
+			Conditions: []SubscriptionCondition{{Kind: ConditionAlert}},
+		}); err == nil {
+			t.Errorf("Add(%q) succeeded, want an error", url)
+		}
+	}
+
+	// The address is checked again when connecting
+	receiver := &webhookReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	_, err = store.post(Subscription{URL: server.URL}, &WebhookDelivery{}, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("post to loopback: err = %v, want a public address error", err)
+	}
+	if n := len(receiver.received()); n != 0 {
+		t.Errorf("receiver got %d requests, want 0", n)
+	}
+}
+
+func TestSubscriptionToken(t *testing.T) {
+	store := newTestSubscriptionStore(t, "")
+	sub := addTestSubscription(t, store, "http://127.0.0.1/hook")
+	if sub.Token == "" || sub.TokenHash != "" {
+		t.Fatalf("created subscription token = %q, hash = %q", sub.Token, sub.TokenHash)
+	}
+	if !store.Authorize(sub.ID, sub.Token) {
+		t.Error("Authorize rejected the subscription token")
+	}
+	if store.Authorize(sub.ID, "wrong") || store.Authorize(sub.ID, "") {
+		t.Error("Authorize accepted a wrong token")
+	}
+	if got, _ := store.Get(sub.ID); got.Secret != "" || got.Token != "" || got.TokenHash != "" {
+		t.Errorf("Get returned credentials: %+v", got)
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int, temp float64, prob int) HourlyWeather {
+		return HourlyWeather{Time: day.Add(time.Duration(h) * time.Hour), Temperature: temp, PrecipitationProb: prob}
+	}
+	light := &LightWeatherResponse{
+		Alerts: []WeatherAlert{
+			{ID: "rain", Type: AlertTypeRainstorm, Color: AlertColorYellow, Status: "预警中"},
+			{ID: "heat", Type: AlertTypeHeatWave, Color: AlertColorOrange, Status: "预警中"},
+			{ID: "gale", Type: AlertTypeGale, Color: AlertColorBlue, Status: "解除"},
+		},
+		Hourly: []HourlyWeather{
+			hour(9, 30, 10),
+			hour(12, 36, 20),
+			hour(15, 37, 70),
+			hour(33, 35, 80), // next day
+			hour(36, 25, 90),
+		},
+	}
+
+	tests := []struct {
+		name string
+		cond SubscriptionCondition
+		keys []string
+	}{
+		{"any alert", SubscriptionCondition{Kind: ConditionAlert},
+			[]string{"alert:rain:yellow", "alert:heat:orange"}},
+		{"alert type", SubscriptionCondition{Kind: ConditionAlert, AlertType: AlertTypeHeatWave},
+			[]string{"alert:heat:orange"}},
+		{"min color", SubscriptionCondition{Kind: ConditionAlert, MinColor: AlertColorOrange},
+			[]string{"alert:heat:orange"}},
+		{"lifted alert", SubscriptionCondition{Kind: ConditionAlert, AlertType: AlertTypeGale},
+			nil},
+		{"no matching alert", SubscriptionCondition{Kind: ConditionAlert, MinColor: AlertColorRed},
+			nil},
+		{"temperature above", SubscriptionCondition{Kind: ConditionTemperatureAbove, Threshold: 35},
+			[]string{"temperature_above:35:2026-07-01"}},
+		{"temperature above, threshold not crossed", SubscriptionCondition{Kind: ConditionTemperatureAbove, Threshold: 37},
+			nil},
+		{"temperature below", SubscriptionCondition{Kind: ConditionTemperatureBelow, Threshold: 31},
+			[]string{"temperature_below:31:2026-07-01", "temperature_below:31:2026-07-02"}},
+		{"precipitation probability above", SubscriptionCondition{Kind: ConditionPrecipitationProbabilityAbove, Threshold: 50.5},
+			[]string{"precipitation_probability_above:50.5:2026-07-01", "precipitation_probability_above:50.5:2026-07-02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := evaluateCondition(tt.cond, "loc", light)
+			var keys []string
+			for _, event := range events {
+				keys = append(keys, event.key)
+				if event.Kind != tt.cond.Kind || event.Location != "loc" {
+					t.Errorf("event %+v has the wrong kind or location", event)
+				}
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+				t.Errorf("keys = %v, want %v", keys, tt.keys)
+			}
+		})
+	}
+
+	// Threshold events report the first matching hour of the day
+	events := evaluateCondition(SubscriptionCondition{Kind: ConditionTemperatureAbove, Threshold: 35}, "loc", light)
+	if len(events) != 1 || !events[0].Time.Equal(day.Add(12*time.Hour)) || events[0].Value != 36 || events[0].Threshold != 35 {
+		t.Errorf("temperature event = %+v, want 36°C at 12:00", events)
+	}
+}
+
+// alertResponse builds a Caiyun response carrying the given alerts, each as
+// ID, code and status
+func alertResponse(t *testing.T, alerts ...[3]string) *CaiyunAPIResponse {
+	t.Helper()
+	var content []map[string]any
+	for _, alert := range alerts {
+		content = append(content, map[string]any{"alertId": alert[0], "code": alert[1], "status": alert[2]})
+	}
+	data, err := json.Marshal(map[string]any{
+		"status":      "ok",
+		"server_time": time.Now().Unix(),
+		"result":      map[string]any{"alert": map[string]any{"content": content}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp CaiyunAPIResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+func TestEvaluateFiresEachEventOnce(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	store := newTestSubscriptionStore(t, "")
+	sub := addTestSubscription(t, store, server.URL)
+	other, err := store.Add(Subscription{
+		URL:        server.URL,
+		Location:   "121.4737,31.2304",
+		Conditions: []SubscriptionCondition{{Kind: ConditionAlert}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		alerts [][3]string
+		want   []string // alert IDs and colors delivered by this step
+	}{
+		{"new alert", [][3]string{{"a1", "0202", "预警中"}}, []string{"a1:yellow"}},
+		{"same alert again", [][3]string{{"a1", "0202", "预警中"}}, nil},
+		{"upgraded color", [][3]string{{"a1", "0203", "预警中"}}, []string{"a1:orange"}},
+		{"second alert", [][3]string{{"a1", "0203", "预警中"}, {"a2", "0701", "预警中"}}, []string{"a2:blue"}},
+		{"lifted", [][3]string{{"a1", "0203", "解除"}, {"a3", "0902", "解除"}}, nil},
+	}
+	seen := 0
+	for _, step := range steps {
+		store.Evaluate(sub.Location, alertResponse(t, step.alerts...))
+		deliveries := store.Deliveries(sub.ID)
+		var got []string
+		for _, d := range deliveries[:len(deliveries)-seen] {
+			got = append(got, d.Event.Alert.ID+":"+d.Event.Alert.Color.String())
+		}
+		seen = len(deliveries)
+		if strings.Join(got, ",") != strings.Join(step.want, ",") {
+			t.Errorf("%s: delivered %v, want %v", step.name, got, step.want)
+		}
+	}
+	if n := len(store.Deliveries(other.ID)); n != 0 {
+		t.Errorf("subscription at another location got %d deliveries", n)
+	}
+}
+
+func TestEvaluateKeepsFiredAlertsInEffect(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	store := newTestSubscriptionStore(t, "")
+	sub := addTestSubscription(t, store, server.URL)
+	resp := alertResponse(t, [3]string{"a1", "0202", "预警中"})
+	store.Evaluate(sub.Location, resp)
+
+	// Age the fired key past the retention while the alert stays in effect
+	store.mu.Lock()
+	for key := range store.state.Fired[sub.ID] {
+		store.state.Fired[sub.ID][key] = time.Now().Add(-firedEventRetention - time.Hour)
+	}
+	store.mu.Unlock()
+	store.Evaluate(sub.Location, resp)
+	if n := len(store.Deliveries(sub.ID)); n != 1 {
+		t.Fatalf("got %d deliveries for an alert in effect, want 1", n)
+	}
+
+	// Once the alert is gone its key expires after the retention
+	store.mu.Lock()
+	for key := range store.state.Fired[sub.ID] {
+		store.state.Fired[sub.ID][key] = time.Now().Add(-firedEventRetention - time.Hour)
+	}
+	store.mu.Unlock()
+	store.Evaluate(sub.Location, alertResponse(t))
+	store.mu.Lock()
+	remaining := len(store.state.Fired[sub.ID])
+	store.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d fired keys kept after the alert ended, want 0", remaining)
+	}
+}
+
+func TestSubscriptionFileIsPrivate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	store := newTestSubscriptionStore(t, path)
+	addTestSubscription(t, store, "http://127.0.0.1/hook")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("subscriptions file mode = %o, want 600", mode)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// windGustFactor relates the peak gust to the mean wind over land. Caiyun
+// only reports mean wind speeds, so gusts are estimated with the typical
+// factor for open terrain from WMO guidance.
+const windGustFactor = 1.5
+
+// beaufortLevel is one step of the Beaufort scale
+type beaufortLevel struct {
+	maxSpeed float64 // upper bound in m/s, exclusive
+	name     localized
+}
+
+// beaufortLevels follows GB/T 28591 up to force 12; stronger winds are
+// reported as force 12
+var beaufortLevels = []beaufortLevel{
+	{0.3, localized{"无风", "Calm"}},
+	{1.6, localized{"软风", "Light air"}},
+	{3.4, localized{"轻风", "Light breeze"}},
+	{5.5, localized{"微风", "Gentle breeze"}},
+	{8.0, localized{"和风", "Moderate breeze"}},
+	{10.8, localized{"清风", "Fresh breeze"}},
+	{13.9, localized{"强风", "Strong breeze"}},
+	{17.2, localized{"疾风", "Near gale"}},
+	{20.8, localized{"大风", "Gale"}},
+	{24.5, localized{"烈风", "Strong gale"}},
+	{28.5, localized{"狂风", "Storm"}},
+	{32.7, localized{"暴风", "Violent storm"}},
+	{math.Inf(1), localized{"飓风", "Hurricane force"}},
+}
+
+// compassPoints are the 16 points of the compass clockwise from north
+var compassPoints = []struct {
+	abbr string
+	name localized
+}{
+	{"N", localized{"北", "North"}},
+	{"NNE", localized{"北东北", "North-northeast"}},
+	{"NE", localized{"东北", "Northeast"}},
+	{"ENE", localized{"东东北", "East-northeast"}},
+	{"E", localized{"东", "East"}},
+	{"ESE", localized{"东东南", "East-southeast"}},
+	{"SE", localized{"东南", "Southeast"}},
+	{"SSE", localized{"南东南", "South-southeast"}},
+	{"S", localized{"南", "South"}},
+	{"SSW", localized{"南西南", "South-southwest"}},
+	{"SW", localized{"西南", "Southwest"}},
+	{"WSW", localized{"西西南", "West-southwest"}},
+	{"W", localized{"西", "West"}},
+	{"WNW", localized{"西西北", "West-northwest"}},
+	{"NW", localized{"西北", "Northwest"}},
+	{"NNW", localized{"北西北", "North-northwest"}},
+}
+
+// WindInfo represents wind information. Speeds are in km/h and the
+// direction is where the wind blows from, in degrees clockwise from north.
+type WindInfo struct {
+	Speed        float64 `json:"speed"`
+	Direction    float64 `json:"direction"`
+	Beaufort     int     `json:"beaufort"`
+	Level        string  `json:"level"`        // Beaufort name, e.g. "和风"
+	Compass      string  `json:"compass"`      // 16-point abbreviation, e.g. "NNE"
+	CompassText  string  `json:"compass_text"` // e.g. "北东北风"
+	Gust         float64 `json:"gust"`         // estimated, see windGustFactor
+	GustBeaufort int     `json:"gust_beaufort"`
+}
+
+// newWindInfo describes a mean wind in lang
+func newWindInfo(speed, direction float64, lang Lang) WindInfo {
+	force := beaufortNumber(speed)
+	point := compassPoints[compassIndex(direction)]
+	gust := roundTo(speed*windGustFactor, 1)
+	return WindInfo{
+		Speed:        speed,
+		Direction:    direction,
+		Beaufort:     force,
+		Level:        beaufortLevels[force].name.in(lang),
+		Compass:      point.abbr,
+		CompassText:  compassText(point.name, force, lang),
+		Gust:         gust,
+		GustBeaufort: beaufortNumber(gust),
+	}
+}
+
+// beaufortNumber returns the Beaufort force of a wind speed in km/h
+func beaufortNumber(speed float64) int {
+	ms := speed / 3.6
+	for force, level := range beaufortLevels {
+		if ms < level.maxSpeed {
+			return force
+		}
+	}
+	return len(beaufortLevels) - 1
+}
+
+// compassIndex maps a direction in degrees to one of the 16 compass points
+func compassIndex(direction float64) int {
+	sector := 360.0 / float64(len(compassPoints))
+	i := int(math.Floor(math.Mod(direction, 360)/sector+0.5)) % len(compassPoints)
+	if i < 0 {
+		i += len(compassPoints)
+	}
+	return i
+}
+
+// compassText names the wind by where it blows from, e.g. "东北风" or
+// "Northeast wind". Calm air has no direction.
+func compassText(name localized, force int, lang Lang) string {
+	if force == 0 {
+		return beaufortLevels[0].name.in(lang)
+	}
+	return name.in(lang) + localized{"风", " wind"}.in(lang)
+}
+
+// windText is a short description such as "东北风4级" or "Northeast wind,
+// force 4"
+func windText(wind WindInfo, lang Lang) string {
+	if wind.Beaufort == 0 {
+		return beaufortLevels[0].name.in(lang)
+	}
+	name := compassPoints[compassIndex(wind.Direction)].name
+	return fmt.Sprintf(localized{"%s%d级", "%s, force %d"}.in(lang), compassText(name, wind.Beaufort, lang), wind.Beaufort)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// windowGoScore is the lowest score at which an event window is a go
+const windowGoScore = 60
+
+// skyconSeverity ranks conditions from harmless (0) to severe for outdoor
+// events; unknown conditions rank as harmless
+var skyconSeverity = map[string]int{
+	"CLEAR_DAY":           0,
+	"CLEAR_NIGHT":         0,
+	"PARTLY_CLOUDY_DAY":   1,
+	"PARTLY_CLOUDY_NIGHT": 1,
+	"CLOUDY":              2,
+	"LIGHT_HAZE":          3,
+	"FOG":                 4,
+	"MODERATE_HAZE":       4,
+	"LIGHT_RAIN":          5,
+	"DUST":                5,
+	"LIGHT_SNOW":          6,
+	"HEAVY_HAZE":          6,
+	"WIND":                6,
+	"MODERATE_RAIN":       7,
+	"SAND":                7,
+	"MODERATE_SNOW":       8,
+	"HEAVY_RAIN":          9,
+	"HEAVY_SNOW":          10,
+	"STORM_RAIN":          11,
+	"STORM_SNOW":          11,
+}
+
+// WindowSummary aggregates the forecast hours of an event window
+type WindowSummary struct {
+	TemperatureMin    float64  `json:"temperature_min"`
+	TemperatureMax    float64  `json:"temperature_max"`
+	ApparentMin       float64  `json:"apparent_temperature_min"`
+	ApparentMax       float64  `json:"apparent_temperature_max"`
+	PrecipitationMM   float64  `json:"precipitation_mm"`          // total
+	PrecipitationProb int      `json:"precipitation_probability"` // highest
+	Wind              WindInfo `json:"wind"`                      // strongest
+	Condition         string   `json:"condition"`                 // worst
+	ConditionText     string   `json:"condition_text"`
+	AQIMax            int      `json:"aqi_max"`
+}
+
+// WindowPenalty is a deduction from the score of a window
+type WindowPenalty struct {
+	Reason string `json:"reason"`
+	Points int    `json:"points"`
+}
+
+// WindowForecast is the go/no-go check for an event window. The score
+// starts at 100 and loses points for rain, uncomfortable temperatures, wind,
+// poor air and bad conditions.
+type WindowForecast struct {
+	Location  string          `json:"location"`
+	Lang      Lang            `json:"lang"`
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Summary   WindowSummary   `json:"summary"`
+	Score     int             `json:"score"`
+	Go        bool            `json:"go"`
+	Penalties []WindowPenalty `json:"penalties"`
+	Hourly    []HourlyWeather `json:"hourly"`
+}
+
+// forecastWindow slices the hours overlapping [start, end) out of the
+// forecast and scores them. Hours that are already over are skipped, and a
+// window reaching past the end of the hourly forecast is an error rather
+// than being scored on the hours that exist.
+func forecastWindow(location string, ext *ExtendedWeatherResponse, start, end time.Time, lang Lang) (*WindowForecast, error) {
+	window := &WindowForecast{
+		Location:  location,
+		Lang:      lang,
+		Start:     start,
+		End:       end,
+		Penalties: []WindowPenalty{},
+		Hourly:    []HourlyWeather{},
+	}
+	summary := &window.Summary
+	summary.TemperatureMin, summary.ApparentMin = math.Inf(1), math.Inf(1)
+	summary.TemperatureMax, summary.ApparentMax = math.Inf(-1), math.Inf(-1)
+	severity := -1
+
+	now := time.Now()
+	var forecastEnd time.Time
+	for _, hour := range ext.Hourly {
+		if hourEnd := hour.Time.Add(time.Hour); hourEnd.After(forecastEnd) {
+			forecastEnd = hourEnd
+		}
+		if !hour.Time.Add(time.Hour).After(start) || !hour.Time.Before(end) || !hour.Time.Add(time.Hour).After(now) {
+			continue
+		}
+		window.Hourly = append(window.Hourly, hour.HourlyWeather)
+
+		summary.TemperatureMin = math.Min(summary.TemperatureMin, hour.Temperature)
+		summary.TemperatureMax = math.Max(summary.TemperatureMax, hour.Temperature)
+		summary.ApparentMin = math.Min(summary.ApparentMin, hour.ApparentTemperature)
+		summary.ApparentMax = math.Max(summary.ApparentMax, hour.ApparentTemperature)
+		summary.PrecipitationMM += hour.PrecipitationMM
+		if hour.PrecipitationProb > summary.PrecipitationProb {
+			summary.PrecipitationProb = hour.PrecipitationProb
+		}
+		if len(window.Hourly) == 1 || hour.Wind.Speed > summary.Wind.Speed {
+			summary.Wind = newWindInfo(hour.Wind.Speed, hour.Wind.Direction, lang)
+		}
+		if s := skyconSeverity[hour.Condition]; s > severity {
+			severity = s
+			summary.Condition = hour.Condition
+		}
+		if hour.AQI > summary.AQIMax {
+			summary.AQIMax = hour.AQI
+		}
+	}
+	if len(window.Hourly) == 0 {
+		return nil, fmt.Errorf("no forecast covers %s to %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	if forecastEnd.Before(end) {
+		return nil, fmt.Errorf("the forecast ends at %s, before the window ends at %s", forecastEnd.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	summary.PrecipitationMM = roundTo(summary.PrecipitationMM, 1)
+	summary.ConditionText = skyconText(summary.Condition, lang)
+
+	penalize := func(reason string, points float64, limit float64) {
+		if points = math.Round(math.Min(points, limit)); points > 0 {
+			window.Penalties = append(window.Penalties, WindowPenalty{Reason: reason, Points: int(points)})
+		}
+	}
+	penalize(adviceReasons["rain"].in(lang), math.Max(float64(summary.PrecipitationProb-30), 0)/2+summary.PrecipitationMM*4, 50)
+	penalize(adviceReasons["cold"].in(lang), (10-summary.ApparentMin)*2, 40)
+	penalize(adviceReasons["hot"].in(lang), (summary.ApparentMax-28)*4, 50)
+	penalize(adviceReasons["wind"].in(lang), float64(summary.Wind.Beaufort-4)*10, 50)
+	penalize(adviceReasons["aqi"].in(lang), float64(summary.AQIMax-100)/4, 50)
+	penalize(summary.ConditionText, float64(severity-4)*8, 60)
+
+	window.Score = 100
+	for _, penalty := range window.Penalties {
+		window.Score -= penalty.Points
+	}
+	window.Score = max(window.Score, 0)
+	window.Go = window.Score >= windowGoScore
+	return window, nil
+}